@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+	"instgram_fans/instagram_fans"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runCommand 处理命令行子命令:
+//
+//	migrate                          执行所有未执行的数据库变更
+//	migrate down [blogger|account]   回滚最近一次变更
+//	migrate status                   查看已执行的变更
+//	accounts history <user> [limit]  查看账号的状态变化记录
+//	accounts genkey                  生成一个新的账号密码加密密钥
+//	accounts rekey [new-key-file]    用新密钥(INS_FANS_NEW_ACCOUNT_KEY 或密钥文件)重新加密所有账号密码
+//	accounts cookie <user> <file>    保存账号的 Cookie 请求头(从浏览器导出), 供 http 抓取后端使用
+//	accounts fingerprint <user>      清除账号的浏览器指纹, 下次登录时重新生成
+//	links <domain>                   查看快拍里链接到 domain 的博主
+//	proxies add <url> [user] [psw]   添加代理, 已有的代理更新账号密码并重新启用
+//	proxies list                     查看所有代理
+//	proxies disable|enable <url>     停用或启用代理
+func runCommand(args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(args[1:])
+	case "accounts":
+		return runAccounts(args[1:])
+	case "links":
+		return runLinks(args[1:])
+	case "proxies":
+		return runProxies(args[1:])
+	default:
+		return errors.Errorf("unknown command %s", args[0])
+	}
+}
+
+func runMigrate(args []string) error {
+	appContext, err := instagram_fans.InitDbContext()
+	if err != nil {
+		return err
+	}
+	defer appContext.DestroyContext()
+
+	if len(args) == 0 || args[0] == "up" {
+		return instagram_fans.MigrateUp(appContext)
+	}
+
+	switch args[0] {
+	case "down":
+		scope := ""
+		if len(args) > 1 {
+			scope = args[1]
+		}
+		return instagram_fans.MigrateDown(appContext, scope)
+	case "status":
+		applied, err := instagram_fans.MigrationStatus(appContext)
+		if err != nil {
+			return err
+		}
+		for _, item := range applied {
+			log.Infof("%s %d_%s applied at %s", item.Scope, item.Version, item.Name, item.AppliedAt.Format("2006-01-02 15:04:05"))
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown migrate command %s", args[0])
+	}
+}
+
+func runAccounts(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing accounts command")
+	}
+	if args[0] == "genkey" {
+		key, err := instagram_fans.GeneratePasswordKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	}
+
+	appContext, err := instagram_fans.InitDbContext()
+	if err != nil {
+		return err
+	}
+	defer appContext.DestroyContext()
+
+	switch args[0] {
+	case "history":
+		if len(args) < 2 {
+			return errors.New("usage: accounts history <user> [limit]")
+		}
+		limit := 50
+		if len(args) > 2 {
+			if limit, err = strconv.Atoi(args[2]); err != nil {
+				return errors.Wrapf(err, "invalid limit %s", args[2])
+			}
+		}
+		history, err := appContext.Accounts.FindAccountHistory(args[1], limit)
+		if err != nil {
+			return err
+		}
+		for _, item := range history {
+			log.Infof("%s %s -> %s reason(%s) machine(%s) blogger(%s)",
+				item.CreatedAt.Format("2006-01-02 15:04:05"), item.OldStatus, item.NewStatus, item.Reason, item.MachineCode, item.BloggerUrl)
+		}
+		return nil
+	case "rekey":
+		newKeyFile := ""
+		if len(args) > 1 {
+			newKeyFile = args[1]
+		}
+		newCipher, err := instagram_fans.LoadPasswordCipher(instagram_fans.NewAccountKeyEnv, newKeyFile)
+		if err != nil {
+			return errors.Wrap(err, "Can not load new account key")
+		}
+		count, err := instagram_fans.RekeyAccountPasswords(appContext.AccountDb, appContext.Config.AccountTable, appContext.Cipher, newCipher)
+		if err != nil {
+			return err
+		}
+		log.Infof("rekey %d account passwords, update %s or accountKeyFile to the new key", count, instagram_fans.AccountKeyEnv)
+		return nil
+	case "cookie":
+		if len(args) < 3 {
+			return errors.New("usage: accounts cookie <user> <cookie-file>")
+		}
+		data, err := os.ReadFile(args[2])
+		if err != nil {
+			return errors.Wrapf(err, "Can not read cookie file %s", args[2])
+		}
+		if err := instagram_fans.SetAccountSessionCookie(appContext.AccountDb, appContext.Config.AccountTable, args[1], strings.TrimSpace(string(data)), appContext.Cipher); err != nil {
+			return err
+		}
+		log.Infof("save session cookie for %s", args[1])
+		return nil
+	case "fingerprint":
+		if len(args) < 2 {
+			return errors.New("usage: accounts fingerprint <user>")
+		}
+		if err := appContext.Accounts.SetAccountFingerprint(args[1], nil); err != nil {
+			return err
+		}
+		log.Infof("reset fingerprint of %s, a new one is generated on next login", args[1])
+		return nil
+	default:
+		return errors.Errorf("unknown accounts command %s", args[0])
+	}
+}
+
+func runLinks(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: links <domain>")
+	}
+	appContext, err := instagram_fans.InitDbContext()
+	if err != nil {
+		return err
+	}
+	defer appContext.DestroyContext()
+
+	links, err := appContext.Bloggers.FindStoryLinksByDomain(args[0])
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		log.Infof("%s %s first seen %s last seen %s", link.BloggerUrl, link.ResolvedLink,
+			link.FirstSeen.Format("2006-01-02 15:04:05"), link.LastSeen.Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+func runProxies(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing proxies command")
+	}
+	appContext, err := instagram_fans.InitDbContext()
+	if err != nil {
+		return err
+	}
+	defer appContext.DestroyContext()
+
+	switch args[0] {
+	case "add":
+		if len(args) < 2 {
+			return errors.New("usage: proxies add <url> [user] [password]")
+		}
+		username, password := "", ""
+		if len(args) > 2 {
+			username = args[2]
+		}
+		if len(args) > 3 {
+			password = args[3]
+		}
+		if err := appContext.Proxies.AddProxy(args[1], username, password); err != nil {
+			return err
+		}
+		log.Infof("add proxy %s", args[1])
+		return nil
+	case "list":
+		proxies, err := appContext.Proxies.FindProxies()
+		if err != nil {
+			return err
+		}
+		for _, proxy := range proxies {
+			lastFailure := "-"
+			if proxy.LastFailureAt != nil {
+				lastFailure = proxy.LastFailureAt.Format("2006-01-02 15:04:05")
+			}
+			log.Infof("%s user(%s) %s fails(%d) last failure %s %s", proxy.Url, proxy.Username, proxy.Status, proxy.FailCount, lastFailure, proxy.LastError)
+		}
+		return nil
+	case "disable", "enable":
+		if len(args) < 2 {
+			return errors.Errorf("usage: proxies %s <url>", args[0])
+		}
+		status := instagram_fans.ProxyStatusActive
+		if args[0] == "disable" {
+			status = instagram_fans.ProxyStatusDisabled
+		}
+		if err := appContext.Proxies.SetProxyStatus(args[1], status); err != nil {
+			return err
+		}
+		log.Infof("proxy %s is %s", args[1], status)
+		return nil
+	default:
+		return errors.Errorf("unknown proxies command %s", args[0])
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"instgram_fans/instagram_fans"
+	"sync"
+)
+
+// fakeScraper 内存里的 Scraper, 按 url 返回预先设置的结果, 用于在没有浏览器的情况下驱动抓取流程
+type fakeScraper struct {
+	Account  *instagram_fans.Account
+	Profiles map[string]instagram_fans.ProfileResult
+	Errors   map[string]error
+	// AlwaysErrors 每次抓取都返回的错误
+	AlwaysErrors map[string]error
+	// ReloginErr 不为空时 Relogin 返回该错误
+	ReloginErr error
+
+	mutex   sync.Mutex
+	fetched []string
+	closed  bool
+}
+
+func (scraper *fakeScraper) FetchProfile(ctx context.Context, url string) (instagram_fans.ProfileResult, error) {
+	if err := ctx.Err(); err != nil {
+		return emptyProfileResult(), err
+	}
+	scraper.mutex.Lock()
+	defer scraper.mutex.Unlock()
+	scraper.fetched = append(scraper.fetched, url)
+
+	if err, ok := scraper.AlwaysErrors[url]; ok {
+		return emptyProfileResult(), err
+	}
+	if err, ok := scraper.Errors[url]; ok {
+		// 错误只返回一次, 模拟重新登录后可以继续抓取
+		delete(scraper.Errors, url)
+		return emptyProfileResult(), err
+	}
+	if profile, ok := scraper.Profiles[url]; ok {
+		return profile, nil
+	}
+	return emptyProfileResult(), instagram_fans.ErrPageUnavailable
+}
+
+func (scraper *fakeScraper) Relogin(ctx context.Context) error {
+	return scraper.ReloginErr
+}
+
+func (scraper *fakeScraper) Close() {
+	scraper.mutex.Lock()
+	defer scraper.mutex.Unlock()
+	scraper.closed = true
+}
+
+// Fetched 返回按顺序抓取过的 url
+func (scraper *fakeScraper) Fetched() []string {
+	scraper.mutex.Lock()
+	defer scraper.mutex.Unlock()
+	return append([]string(nil), scraper.fetched...)
+}
+
+func (scraper *fakeScraper) Closed() bool {
+	scraper.mutex.Lock()
+	defer scraper.mutex.Unlock()
+	return scraper.closed
+}
+
+// emptyProfileResult 没有抓到数据时的结果, 与 Scraper 的实现一致
+func emptyProfileResult() instagram_fans.ProfileResult {
+	return instagram_fans.ProfileResult{FansCount: -2, FollowingCount: -2, PostCount: -2}
+}
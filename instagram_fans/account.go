@@ -0,0 +1,136 @@
+package instagram_fans
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// AccountStatus 账号状态, 数值与账号表 status 列里已有的数据保持一致
+type AccountStatus int
+
+const (
+	AccountStatusIdle     AccountStatus = 0  // 空闲, 可以使用
+	AccountStatusInUse    AccountStatus = 1  // 正在被某台机器使用
+	AccountStatusInvalid  AccountStatus = -1 // 密码错误、被封等, 需要人工处理
+	AccountStatusUnusable AccountStatus = -2 // 需要验证, 冷却一段时间后自动恢复
+)
+
+func (status AccountStatus) String() string {
+	switch status {
+	case AccountStatusIdle:
+		return "idle"
+	case AccountStatusInUse:
+		return "in_use"
+	case AccountStatusInvalid:
+		return "invalid"
+	case AccountStatusUnusable:
+		return "unusable"
+	default:
+		return "unknown"
+	}
+}
+
+// AccountStatusReason 账号状态变化的原因
+type AccountStatusReason string
+
+const (
+	AccountReasonNone            AccountStatusReason = ""
+	AccountReasonWrongPassword   AccountStatusReason = "wrong_password"
+	AccountReasonSuspended       AccountStatusReason = "suspended"
+	AccountReasonSuspiciousLogin AccountStatusReason = "suspicious_login"
+	AccountReasonHelpConfirm     AccountStatusReason = "help_confirm"
+	AccountReasonHttpError       AccountStatusReason = "http_error"
+	AccountReasonLoginFailed     AccountStatusReason = "login_failed"
+	AccountReasonPageError       AccountStatusReason = "page_error"
+	AccountReasonCooldownExpired AccountStatusReason = "cooldown_expired"
+	AccountReasonReleased        AccountStatusReason = "released"
+	AccountReasonMachineChanged  AccountStatusReason = "machine_changed"
+	AccountReasonSessionExpired  AccountStatusReason = "session_expired"
+	AccountReasonRateLimited     AccountStatusReason = "rate_limited"
+	AccountReasonProxyFailed     AccountStatusReason = "proxy_failed"
+	AccountReasonDecryptFailed   AccountStatusReason = "decrypt_failed" // 密码无法用当前密钥解密, 需要检查密钥配置
+)
+
+// Account 账号, Password / SessionCookie 是数据库里保存的密文, 只在使用时解密
+type Account struct {
+	Username        string              `gorm:"column:user"`
+	Password        Secret              `gorm:"column:psw"`
+	Status          AccountStatus       `gorm:"column:status"`
+	StatusReason    AccountStatusReason `gorm:"column:status_reason"`
+	StatusChangedAt *time.Time          `gorm:"column:status_changed_at"`
+	MachineCode     string              `gorm:"column:Machine_code"`
+	// SessionCookie 浏览器里导出的 Cookie 请求头, 如 "sessionid=...; csrftoken=...", 供 http 后端使用
+	SessionCookie Secret `gorm:"column:session_cookie"`
+	// ProxyId 绑定的代理, 为空时直接连接
+	ProxyId *int `gorm:"column:proxy_id"`
+	// Fingerprint 浏览器后端使用的指纹, 第一次使用账号时生成
+	Fingerprint *Fingerprint `gorm:"column:fingerprint"`
+
+	cipher *PasswordCipher
+}
+
+// RevealPassword 解密账号密码, 明文会登记到日志脱敏列表里
+func (account *Account) RevealPassword() (string, error) {
+	password, err := account.cipher.Decrypt(string(account.Password))
+	if err == nil {
+		RegisterSecret(password)
+	}
+	return password, err
+}
+
+// RevealSessionCookie 解密账号的 Cookie, 其中每个 cookie 的值都会登记到日志脱敏列表里
+func (account *Account) RevealSessionCookie() ([]*http.Cookie, error) {
+	header, err := account.cipher.Decrypt(string(account.SessionCookie))
+	if err != nil {
+		return nil, err
+	}
+	cookies := (&http.Request{Header: http.Header{"Cookie": {header}}}).Cookies()
+	for _, cookie := range cookies {
+		RegisterSecret(cookie.Value)
+	}
+	return cookies, nil
+}
+
+// String 打印账号时不包含密码
+func (account Account) String() string {
+	return fmt.Sprintf("{user:%s status:%s reason:%s machine:%s}", account.Username, account.Status, account.StatusReason, account.MachineCode)
+}
+
+func (account Account) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("user", account.Username),
+		slog.String("status", account.Status.String()),
+		slog.String("reason", string(account.StatusReason)),
+		slog.String("machine", account.MachineCode),
+	)
+}
+
+// AccountError 带原因的账号错误, errors.Is 仍然可以匹配 ErrUserInvalid / ErrUserUnusable
+type AccountError struct {
+	Err    error
+	Reason AccountStatusReason
+}
+
+func (e *AccountError) Error() string {
+	return e.Err.Error() + " (" + string(e.Reason) + ")"
+}
+
+func (e *AccountError) Unwrap() error {
+	return e.Err
+}
+
+func newAccountError(err error, reason AccountStatusReason) error {
+	return &AccountError{Err: err, Reason: reason}
+}
+
+// AccountErrorReason 取出错误里记录的账号状态原因
+func AccountErrorReason(err error) AccountStatusReason {
+	var accountErr *AccountError
+	if errors.As(err, &accountErr) {
+		return accountErr.Reason
+	}
+	return AccountReasonNone
+}
@@ -0,0 +1,60 @@
+package instagram_fans
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// AccountStatusChange 一次账号状态变化, BloggerUrl 为发生变化时正在抓取的博主
+type AccountStatusChange struct {
+	Status AccountStatus
+	Reason AccountStatusReason
+	// MachineCode 为空时保留账号原来的机器码
+	MachineCode string
+	BloggerUrl  string
+}
+
+// AccountAudit 账号状态变化的审计记录, 与账号表在同一个库里
+type AccountAudit struct {
+	Id          int                 `gorm:"primaryKey"`
+	Account     string              `gorm:"column:account;type:varchar(128);index:idx_account_audit_account_time,priority:1"`
+	OldStatus   AccountStatus       `gorm:"column:old_status"`
+	NewStatus   AccountStatus       `gorm:"column:new_status"`
+	Reason      AccountStatusReason `gorm:"column:reason;type:varchar(32)"`
+	MachineCode string              `gorm:"column:machine_code;type:varchar(64)"`
+	BloggerUrl  string              `gorm:"column:blogger_url;type:varchar(512)"`
+	CreatedAt   time.Time           `gorm:"column:created_at;index:idx_account_audit_account_time,priority:2"`
+}
+
+func (AccountAudit) TableName() string {
+	return "account_audit"
+}
+
+func newAccountAudit(current *Account, change AccountStatusChange, now time.Time) *AccountAudit {
+	machineCode := change.MachineCode
+	if machineCode == "" {
+		machineCode = current.MachineCode
+	}
+	return &AccountAudit{
+		Account:     current.Username,
+		OldStatus:   current.Status,
+		NewStatus:   change.Status,
+		Reason:      change.Reason,
+		MachineCode: machineCode,
+		BloggerUrl:  change.BloggerUrl,
+		CreatedAt:   now,
+	}
+}
+
+// FindAccountHistory 按时间倒序返回账号最近的 limit 条状态变化, limit <= 0 时返回全部
+func FindAccountHistory(db *gorm.DB, username string, limit int) ([]*AccountAudit, error) {
+	var audits []*AccountAudit
+	query := db.Where("account = ?", username).Order("created_at DESC, id DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&audits).Error; err != nil {
+		return nil, err
+	}
+	return audits, nil
+}
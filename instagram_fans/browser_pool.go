@@ -0,0 +1,307 @@
+package instagram_fans
+
+import (
+	"bytes"
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+	"github.com/playwright-community/playwright-go"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrBrowserPoolClosed = errors.New("browser pool is closed")
+
+// memoryCheckInterval 两次扫描 /proc 统计内存的最短间隔
+const memoryCheckInterval = 30 * time.Second
+
+// BrowserPool 进程内共享的浏览器, 每个账号使用独立的 BrowserContext。
+// 当前浏览器打开过 maxContexts 个上下文或进程内存超过 maxMemory 后换一个新浏览器,
+// 旧浏览器等它的上下文都关闭后再关闭。内存统计包含所有浏览器, 有旧浏览器还没关闭时不按内存换浏览器
+type BrowserPool struct {
+	launch      func() (playwright.Browser, error)
+	maxContexts int
+	maxMemory   uint64 // 字节, 0 表示不检查
+	// memoryUsage 浏览器占用的内存, 默认统计当前进程所有子进程的 RSS
+	memoryUsage func() (uint64, error)
+	// memoryInterval 内存统计结果的缓存时间, 统计在 mutex 之外进行
+	memoryInterval time.Duration
+
+	memoryMutex     sync.Mutex
+	memoryCheckedAt time.Time
+	memory          uint64
+
+	mutex   sync.Mutex
+	current *pooledBrowser
+	retired []*pooledBrowser
+	closed  bool
+}
+
+type pooledBrowser struct {
+	browser  playwright.Browser
+	opened   int // 打开过的上下文数量
+	contexts map[*PooledContext]bool
+}
+
+// PooledContext 从 BrowserPool 取出的上下文, 用完后必须 Close
+type PooledContext struct {
+	Context playwright.BrowserContext
+	pool    *BrowserPool
+	owner   *pooledBrowser
+}
+
+func NewBrowserPool(launch func() (playwright.Browser, error), maxContexts int, maxMemoryMB int) *BrowserPool {
+	return &BrowserPool{
+		launch:         launch,
+		maxContexts:    maxContexts,
+		maxMemory:      uint64(maxMemoryMB) * 1024 * 1024,
+		memoryUsage:    childProcessMemory,
+		memoryInterval: memoryCheckInterval,
+	}
+}
+
+// NewContext 在当前浏览器上创建一个新的上下文, 需要时先换浏览器
+func (pool *BrowserPool) NewContext(options playwright.BrowserNewContextOptions) (*PooledContext, error) {
+	usage, measured := pool.sampleMemory()
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if pool.closed {
+		return nil, ErrBrowserPoolClosed
+	}
+	if pool.current != nil && pool.shouldRecycle(pool.current, usage, measured) {
+		pool.retire(pool.current)
+		pool.current = nil
+	}
+	if err := pool.ensureBrowser(); err != nil {
+		return nil, err
+	}
+
+	context, err := pool.current.browser.NewContext(options)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can not create browser context")
+	}
+	pooled := &PooledContext{Context: context, pool: pool, owner: pool.current}
+	pool.current.opened++
+	pool.current.contexts[pooled] = true
+	return pooled, nil
+}
+
+// ensureBrowser 还没有当前浏览器时启动一个
+func (pool *BrowserPool) ensureBrowser() error {
+	if pool.current != nil {
+		return nil
+	}
+	browser, err := pool.launch()
+	if err != nil {
+		return errors.Wrap(err, "Can not launch browser")
+	}
+	pool.current = &pooledBrowser{browser: browser, contexts: make(map[*PooledContext]bool)}
+	return nil
+}
+
+// BrowserVersion 当前浏览器的版本, 还没有启动浏览器时先启动一个
+func (pool *BrowserPool) BrowserVersion() (string, error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.closed {
+		return "", ErrBrowserPoolClosed
+	}
+	if err := pool.ensureBrowser(); err != nil {
+		return "", err
+	}
+	return pool.current.browser.Version(), nil
+}
+
+func (pool *BrowserPool) shouldRecycle(current *pooledBrowser, usage uint64, measured bool) bool {
+	if !current.browser.IsConnected() {
+		log.Warnf("[BrowserPool] browser is disconnected, launch a new one")
+		return true
+	}
+	if pool.maxContexts > 0 && current.opened >= pool.maxContexts {
+		log.Infof("[BrowserPool] browser has opened %d contexts, recycle it", current.opened)
+		return true
+	}
+	// 统计的是所有浏览器的内存, 旧浏览器还在运行时换浏览器只会不断启动新浏览器
+	if measured && len(pool.retired) == 0 && usage > pool.maxMemory {
+		log.Infof("[BrowserPool] browsers use %d MB memory, recycle", usage/1024/1024)
+		return true
+	}
+	return false
+}
+
+// sampleMemory 返回浏览器占用的内存, memoryInterval 内重复使用上一次的结果。没有设置 maxMemory 或读取失败时 measured 为 false
+func (pool *BrowserPool) sampleMemory() (usage uint64, measured bool) {
+	if pool.maxMemory == 0 {
+		return 0, false
+	}
+	pool.memoryMutex.Lock()
+	defer pool.memoryMutex.Unlock()
+	if !pool.memoryCheckedAt.IsZero() && time.Since(pool.memoryCheckedAt) < pool.memoryInterval {
+		return pool.memory, true
+	}
+	usage, err := pool.memoryUsage()
+	if err != nil {
+		log.Warnf("[BrowserPool] Can not read memory usage, %v", err)
+		return 0, false
+	}
+	pool.memory = usage
+	pool.memoryCheckedAt = time.Now()
+	return usage, true
+}
+
+// forgetMemory 有浏览器关闭后丢弃缓存的内存统计, 下次重新读取
+func (pool *BrowserPool) forgetMemory() {
+	pool.memoryMutex.Lock()
+	defer pool.memoryMutex.Unlock()
+	pool.memoryCheckedAt = time.Time{}
+}
+
+// retire 不再从 browser 分配上下文, 没有打开的上下文时立即关闭
+func (pool *BrowserPool) retire(browser *pooledBrowser) {
+	if len(browser.contexts) == 0 {
+		closeBrowser(browser.browser)
+		pool.forgetMemory()
+		return
+	}
+	pool.retired = append(pool.retired, browser)
+}
+
+// OpenContexts 当前打开的上下文数量
+func (pool *BrowserPool) OpenContexts() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	count := 0
+	if pool.current != nil {
+		count += len(pool.current.contexts)
+	}
+	for _, browser := range pool.retired {
+		count += len(browser.contexts)
+	}
+	return count
+}
+
+// Close 关闭所有上下文和浏览器, 之后不能再创建上下文
+func (pool *BrowserPool) Close() {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.closed {
+		return
+	}
+	pool.closed = true
+
+	browsers := pool.retired
+	if pool.current != nil {
+		browsers = append(browsers, pool.current)
+	}
+	for _, browser := range browsers {
+		for context := range browser.contexts {
+			if err := context.Context.Close(); err != nil {
+				log.Errorf("[BrowserPool] Can not close browser context, %v", err)
+			}
+		}
+		browser.contexts = nil
+		closeBrowser(browser.browser)
+	}
+	pool.current = nil
+	pool.retired = nil
+}
+
+// Close 关闭上下文, 所属的浏览器已经退役且没有其他上下文时一起关闭
+func (context *PooledContext) Close() {
+	pool := context.pool
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	owner := context.owner
+	if !owner.contexts[context] {
+		return
+	}
+	delete(owner.contexts, context)
+	if err := context.Context.Close(); err != nil {
+		log.Errorf("[BrowserPool] Can not close browser context, %v", err)
+	}
+
+	if owner == pool.current || len(owner.contexts) > 0 {
+		return
+	}
+	for i, browser := range pool.retired {
+		if browser == owner {
+			pool.retired = append(pool.retired[:i], pool.retired[i+1:]...)
+			closeBrowser(owner.browser)
+			pool.forgetMemory()
+			break
+		}
+	}
+}
+
+func closeBrowser(browser playwright.Browser) {
+	if err := browser.Close(); err != nil {
+		log.Errorf("[BrowserPool] Can not close browser, %v", err)
+	}
+}
+
+// childProcessMemory 当前进程所有子孙进程(playwright driver 和浏览器)的 RSS 之和, 只支持 Linux
+func childProcessMemory() (uint64, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	parents := make(map[int]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// /proc/<pid>/stat 的第二列是带括号的进程名, 里面可能有空格, 从最后一个 ')' 之后开始取
+		fields := bytes.Fields(stat[bytes.LastIndexByte(stat, ')')+1:])
+		if len(fields) < 2 {
+			continue
+		}
+		if ppid, err := strconv.Atoi(string(fields[1])); err == nil {
+			parents[pid] = ppid
+		}
+	}
+
+	self := os.Getpid()
+	pageSize := uint64(os.Getpagesize())
+	var total uint64
+	for pid := range parents {
+		if !isDescendant(parents, pid, self) {
+			continue
+		}
+		statm, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "statm"))
+		if err != nil {
+			continue
+		}
+		fields := bytes.Fields(statm)
+		if len(fields) < 2 {
+			continue
+		}
+		if pages, err := strconv.ParseUint(string(fields[1]), 10, 64); err == nil {
+			total += pages * pageSize
+		}
+	}
+	return total, nil
+}
+
+func isDescendant(parents map[int]int, pid int, ancestor int) bool {
+	for depth := 0; depth < 64 && pid > 1; depth++ {
+		ppid, ok := parents[pid]
+		if !ok {
+			return false
+		}
+		if ppid == ancestor {
+			return true
+		}
+		pid = ppid
+	}
+	return false
+}
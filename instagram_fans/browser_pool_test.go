@@ -0,0 +1,170 @@
+package instagram_fans
+
+import (
+	"github.com/playwright-community/playwright-go"
+	"testing"
+	"time"
+)
+
+// fakeBrowser 只实现 BrowserPool 和 PlaywrightScraper 用到的方法
+type fakeBrowser struct {
+	playwright.Browser
+	contexts []*fakeBrowserContext
+	closed   bool
+}
+
+func (browser *fakeBrowser) NewContext(options ...playwright.BrowserNewContextOptions) (playwright.BrowserContext, error) {
+	context := &fakeBrowserContext{}
+	if len(options) > 0 {
+		context.options = options[0]
+	}
+	browser.contexts = append(browser.contexts, context)
+	return context, nil
+}
+
+func (browser *fakeBrowser) Version() string {
+	return "124.0.6367.29"
+}
+
+func (browser *fakeBrowser) IsConnected() bool {
+	return !browser.closed
+}
+
+func (browser *fakeBrowser) Close(options ...playwright.BrowserCloseOptions) error {
+	browser.closed = true
+	return nil
+}
+
+type fakeBrowserContext struct {
+	playwright.BrowserContext
+	options playwright.BrowserNewContextOptions
+	// state StorageState 返回的登录状态
+	state  *playwright.StorageState
+	closed bool
+}
+
+func (context *fakeBrowserContext) NewPage() (playwright.Page, error) {
+	return &fakePage{context: context}, nil
+}
+
+func (context *fakeBrowserContext) StorageState(path ...string) (*playwright.StorageState, error) {
+	return context.state, nil
+}
+
+type fakePage struct {
+	playwright.Page
+	context *fakeBrowserContext
+	closed  bool
+}
+
+func (page *fakePage) Context() playwright.BrowserContext {
+	return page.context
+}
+
+func (page *fakePage) Fill(selector string, value string, options ...playwright.PageFillOptions) error {
+	return nil
+}
+
+func (page *fakePage) Close(options ...playwright.PageCloseOptions) error {
+	page.closed = true
+	return nil
+}
+
+func (context *fakeBrowserContext) Close(options ...playwright.BrowserContextCloseOptions) error {
+	context.closed = true
+	return nil
+}
+
+func newFakeBrowserPool(maxContexts int) (*BrowserPool, *[]*fakeBrowser) {
+	var browsers []*fakeBrowser
+	pool := NewBrowserPool(func() (playwright.Browser, error) {
+		browser := &fakeBrowser{}
+		browsers = append(browsers, browser)
+		return browser, nil
+	}, maxContexts, 0)
+	return pool, &browsers
+}
+
+func TestBrowserPoolRecyclesAfterMaxContexts(t *testing.T) {
+	pool, browsers := newFakeBrowserPool(2)
+
+	first, _ := pool.NewContext(playwright.BrowserNewContextOptions{})
+	second, _ := pool.NewContext(playwright.BrowserNewContextOptions{})
+	if len(*browsers) != 1 {
+		t.Fatalf("two contexts should share one browser, got %d browsers", len(*browsers))
+	}
+
+	third, err := pool.NewContext(playwright.BrowserNewContextOptions{})
+	if err != nil || len(*browsers) != 2 {
+		t.Fatalf("third context should launch a new browser, got %d browsers, %v", len(*browsers), err)
+	}
+	old := (*browsers)[0]
+
+	first.Close()
+	if old.closed {
+		t.Fatalf("retired browser must stay open while it has contexts")
+	}
+	second.Close()
+	if !old.closed || !old.contexts[0].closed || !old.contexts[1].closed {
+		t.Fatalf("retired browser and its contexts should be closed")
+	}
+	if pool.OpenContexts() != 1 {
+		t.Fatalf("got %d open contexts, want 1", pool.OpenContexts())
+	}
+
+	pool.Close()
+	if !(*browsers)[1].closed || !(*browsers)[1].contexts[0].closed {
+		t.Fatalf("pool close should close the current browser and its contexts")
+	}
+	third.Close()
+	if _, err := pool.NewContext(playwright.BrowserNewContextOptions{}); err != ErrBrowserPoolClosed {
+		t.Fatalf("got %v after close, want ErrBrowserPoolClosed", err)
+	}
+}
+
+func TestBrowserPoolRecyclesOnMemoryThreshold(t *testing.T) {
+	pool, browsers := newFakeBrowserPool(100)
+	pool.maxMemory = 100
+	pool.memoryInterval = 0
+	usage := uint64(50)
+	pool.memoryUsage = func() (uint64, error) { return usage, nil }
+
+	context, _ := pool.NewContext(playwright.BrowserNewContextOptions{})
+	context.Close()
+	usage = 200
+	pool.NewContext(playwright.BrowserNewContextOptions{})
+	if len(*browsers) != 2 || !(*browsers)[0].closed {
+		t.Fatalf("browser over the memory threshold should be replaced, got %d browsers", len(*browsers))
+	}
+}
+
+func TestBrowserPoolWaitsForRetiredBrowsersBeforeMemoryRecycle(t *testing.T) {
+	pool, browsers := newFakeBrowserPool(100)
+	pool.maxMemory = 100
+	pool.memoryInterval = time.Hour
+	usage := uint64(200)
+	reads := 0
+	pool.memoryUsage = func() (uint64, error) {
+		reads++
+		return usage, nil
+	}
+
+	first, _ := pool.NewContext(playwright.BrowserNewContextOptions{})
+	// 第一个浏览器被换掉后还有打开的上下文, 内存里仍然包含它, 不能因此继续换浏览器
+	pool.NewContext(playwright.BrowserNewContextOptions{})
+	pool.NewContext(playwright.BrowserNewContextOptions{})
+	if len(*browsers) != 2 || (*browsers)[0].closed {
+		t.Fatalf("expect one recycle while the old browser drains, got %d browsers", len(*browsers))
+	}
+	if reads != 1 {
+		t.Fatalf("memory should be read once within the interval, got %d reads", reads)
+	}
+
+	// 旧浏览器关闭后重新统计内存
+	first.Close()
+	usage = 50
+	pool.NewContext(playwright.BrowserNewContextOptions{})
+	if !(*browsers)[0].closed || len(*browsers) != 2 || reads != 2 {
+		t.Fatalf("expect the old browser closed and memory read again, got %d browsers, %d reads", len(*browsers), reads)
+	}
+}
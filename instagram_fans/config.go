@@ -2,8 +2,12 @@ package instagram_fans
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+	"log/slog"
 	"os"
+	"time"
 )
 
 type DelayConfig struct {
@@ -14,15 +18,226 @@ type DelayConfig struct {
 type Config struct {
 	AccountCount   int         `json:"accountCount"`
 	DelayConfig    DelayConfig `json:"delay_config"`
+	Driver         string      `json:"driver"` // mysql(默认) 或 sqlite, 为 sqlite 时 dsn 是数据库文件路径
 	Dsn            string      `json:"dsn"`
 	Table          string      `json:"table"`
 	Count          int         `json:"count"`
 	MaxCount       int         `json:"maxCount"`
+	AccountDriver  string      `json:"accountDriver"` // 为空时与 driver 相同
 	AccountDSN     string      `json:"accountDsn"`
 	AccountTable   string      `json:"accountTable"`
+	AccountKeyFile string      `json:"accountKeyFile"` // 账号密码加密密钥文件, 环境变量 INS_FANS_ACCOUNT_KEY 优先
 	ParseFansCount bool        `json:"parseFansCount"`
 	ParseStoryLink bool        `json:"parseStoryLink"`
-	ShowBrowser    bool        `json:"showBrowser"`
+	// 以下资料和粉丝数在同一次打开主页时解析, 各自开启后才写入数据库
+	ParseFollowingCount bool `json:"parseFollowingCount"`
+	ParsePostCount      bool `json:"parsePostCount"`
+	ParseFullName       bool `json:"parseFullName"`
+	ParseBiography      bool `json:"parseBiography"`
+	ParseExternalUrl    bool `json:"parseExternalUrl"`
+	ParseVerified       bool `json:"parseVerified"`
+	ParseCategory       bool `json:"parseCategory"`
+	ParsePrivate        bool `json:"parsePrivate"`
+	ShowBrowser         bool `json:"showBrowser"`
+	// 浏览器启动参数: browserEngine 为 chromium(默认)、firefox 或 webkit,
+	// 配置 browserCdpUrl(如 http://127.0.0.1:9222)时连接已经在运行的 Chromium 而不是启动新的
+	BrowserEngine         string   `json:"browserEngine"`
+	BrowserExecutablePath string   `json:"browserExecutablePath"`
+	BrowserArgs           []string `json:"browserArgs"`
+	BrowserSlowMoMillis   int      `json:"browserSlowMoMillis"` // 每个操作之间的延迟, 调试时使用
+	BrowserCdpUrl         string   `json:"browserCdpUrl"`
+	// FingerprintDevices 生成账号指纹时模拟的 playwright 设备(如 "iPhone 13"、"Pixel 7"), 为空时生成桌面浏览器指纹
+	FingerprintDevices []string `json:"fingerprintDevices"`
+	// SessionDir 保存账号浏览器登录状态的目录, 默认 sessions
+	SessionDir string `json:"sessionDir"`
+	// BrowserMaxContexts 一个浏览器打开多少个账号上下文后换新的浏览器, 默认 20
+	BrowserMaxContexts int `json:"browserMaxContexts"`
+	// BrowserMaxMemoryMB 浏览器进程占用的内存超过后换新的浏览器, 0 表示不限制
+	BrowserMaxMemoryMB int `json:"browserMaxMemoryMB"`
+	LeaseSeconds       int `json:"leaseSeconds"`
+	// MaxScrapeAttempts 抓取失败或主页打不开的博主最多连续尝试几次, 达到后启动时不再重新排队, 默认 3
+	MaxScrapeAttempts int `json:"maxScrapeAttempts"`
+	// AccountCooldownMinutes 账号因 "Help us confirm it" 等原因不可用后, 多久自动恢复
+	AccountCooldownMinutes int `json:"accountCooldownMinutes"`
+	// Scraper 抓取后端: browser(默认) 用浏览器打开主页, http 用账号 Cookie 直接请求网页版接口
+	Scraper            string `json:"scraper"`
+	HttpBaseUrl        string `json:"httpBaseUrl"` // http 后端请求的地址, 默认 https://www.instagram.com
+	HttpTimeoutSeconds int    `json:"httpTimeoutSeconds"`
+	// ResolveStoryLinks 跟随快拍外链的短链跳转(bit.ly、linktr.ee 等), 记录最终地址和跳转链
+	ResolveStoryLinks           bool `json:"resolveStoryLinks"`
+	ResolveMaxHops              int  `json:"resolveMaxHops"`
+	ResolveTimeoutSeconds       int  `json:"resolveTimeoutSeconds"`
+	ResolveDomainIntervalMillis int  `json:"resolveDomainIntervalMillis"` // 同一个域名两次请求的最小间隔
+	// 代理: 账号绑定 proxy 表里的代理, 使用前先请求 proxyCheckUrl 检查, 失败的代理冷却 proxyCooldownMinutes 后重新检查
+	ProxyCheckUrl            string `json:"proxyCheckUrl"`
+	ProxyCheckTimeoutSeconds int    `json:"proxyCheckTimeoutSeconds"`
+	ProxyCooldownMinutes     int    `json:"proxyCooldownMinutes"`
+}
+
+// String 打印配置时遮蔽数据库密码
+func (config Config) String() string {
+	return fmt.Sprintf("{accountCount:%d driver:%s dsn:%s table:%s count:%d maxCount:%d accountDriver:%s accountDsn:%s accountTable:%s parseFansCount:%v parseStoryLink:%v showBrowser:%v browser:%s scraper:%s}",
+		config.AccountCount, config.Driver, RedactDSN(config.Dsn), config.Table, config.Count, config.MaxCount,
+		config.AccountDriverName(), RedactDSN(config.AccountDSN), config.AccountTable, config.ParseFansCount, config.ParseStoryLink, config.ShowBrowser, config.BrowserEngineName(), config.ScraperName())
+}
+
+// LogValue 结构化日志里使用的配置, 同样遮蔽数据库密码
+func (config Config) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("driver", config.Driver),
+		slog.String("dsn", RedactDSN(config.Dsn)),
+		slog.String("table", config.Table),
+		slog.String("accountDsn", RedactDSN(config.AccountDSN)),
+		slog.String("accountTable", config.AccountTable),
+		slog.Int("accountCount", config.AccountCount),
+	)
+}
+
+// AccountDriverName 账号库使用的数据库驱动
+func (config *Config) AccountDriverName() string {
+	if config.AccountDriver == "" {
+		return config.Driver
+	}
+	return config.AccountDriver
+}
+
+// AccountCooldown 不可用账号的冷却时长, 未配置时为 24 小时
+func (config *Config) AccountCooldown() time.Duration {
+	if config.AccountCooldownMinutes <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(config.AccountCooldownMinutes) * time.Minute
+}
+
+// ProxyCheckAddress 检查代理时请求的地址, 未配置时为 https://www.instagram.com/
+func (config *Config) ProxyCheckAddress() string {
+	if config.ProxyCheckUrl == "" {
+		return "https://www.instagram.com/"
+	}
+	return config.ProxyCheckUrl
+}
+
+// ProxyCheckTimeout 检查代理的超时时间, 未配置时为 10 秒
+func (config *Config) ProxyCheckTimeout() time.Duration {
+	if config.ProxyCheckTimeoutSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(config.ProxyCheckTimeoutSeconds) * time.Second
+}
+
+// ProxyCooldown 失败的代理多久后重新检查, 未配置时为 30 分钟
+func (config *Config) ProxyCooldown() time.Duration {
+	if config.ProxyCooldownMinutes <= 0 {
+		return 30 * time.Minute
+	}
+	return time.Duration(config.ProxyCooldownMinutes) * time.Minute
+}
+
+// LeaseDuration 领取博主后的租约时长, 未配置时为 5 分钟
+func (config *Config) LeaseDuration() time.Duration {
+	if config.LeaseSeconds <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(config.LeaseSeconds) * time.Second
+}
+
+// ScrapeAttemptLimit 博主连续失败多少次后不再重新排队
+func (config *Config) ScrapeAttemptLimit() int {
+	if config.MaxScrapeAttempts <= 0 {
+		return 3
+	}
+	return config.MaxScrapeAttempts
+}
+
+// ParseProfileDetails 是否需要解析粉丝数以外的主页资料
+func (config *Config) ParseProfileDetails() bool {
+	return config.ParseFollowingCount || config.ParsePostCount || config.ParseFullName || config.ParseBiography ||
+		config.ParseExternalUrl || config.ParseVerified || config.ParseCategory || config.ParsePrivate
+}
+
+// ParseProfile 是否需要打开博主主页
+func (config *Config) ParseProfile() bool {
+	return config.ParseFansCount || config.ParseProfileDetails()
+}
+
+// SessionDirectory 保存账号浏览器登录状态的目录
+func (config *Config) SessionDirectory() string {
+	if config.SessionDir == "" {
+		return "sessions"
+	}
+	return config.SessionDir
+}
+
+// BrowserContextLimit 一个浏览器最多打开的上下文数量
+func (config *Config) BrowserContextLimit() int {
+	if config.BrowserMaxContexts <= 0 {
+		return 20
+	}
+	return config.BrowserMaxContexts
+}
+
+// BrowserEngineName 使用的浏览器, 未配置时为 chromium
+func (config *Config) BrowserEngineName() string {
+	if config.BrowserEngine == "" {
+		return BrowserChromium
+	}
+	return config.BrowserEngine
+}
+
+// ScraperName 使用的抓取后端, 未配置时为 browser
+func (config *Config) ScraperName() string {
+	if config.Scraper == "" {
+		return ScraperBrowser
+	}
+	return config.Scraper
+}
+
+// HttpTimeout http 后端单个请求的超时时间, 未配置时为 30 秒
+func (config *Config) HttpTimeout() time.Duration {
+	if config.HttpTimeoutSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(config.HttpTimeoutSeconds) * time.Second
+}
+
+// ResolveMaxHopCount 解析外链时最多跟随的跳转次数, 未配置时为 10
+func (config *Config) ResolveMaxHopCount() int {
+	if config.ResolveMaxHops <= 0 {
+		return 10
+	}
+	return config.ResolveMaxHops
+}
+
+// ResolveTimeout 解析外链时单次请求的超时时间, 未配置时为 10 秒
+func (config *Config) ResolveTimeout() time.Duration {
+	if config.ResolveTimeoutSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(config.ResolveTimeoutSeconds) * time.Second
+}
+
+// ResolveDomainInterval 同一个域名两次请求的最小间隔, 未配置时为 1 秒
+func (config *Config) ResolveDomainInterval() time.Duration {
+	if config.ResolveDomainIntervalMillis <= 0 {
+		return time.Second
+	}
+	return time.Duration(config.ResolveDomainIntervalMillis) * time.Millisecond
+}
+
+func (config *Config) validateBrowser() error {
+	engine := config.BrowserEngineName()
+	if engine != BrowserChromium && engine != BrowserFirefox && engine != BrowserWebkit {
+		return errors.Errorf("unknown browser engine %q", engine)
+	}
+	// playwright 只支持通过 CDP 连接 Chromium
+	if config.BrowserCdpUrl != "" && engine != BrowserChromium {
+		return errors.Errorf("browserCdpUrl only works with chromium, got %s", engine)
+	}
+	// Firefox 不支持移动设备模拟
+	if len(config.FingerprintDevices) > 0 && engine == BrowserFirefox {
+		return errors.Errorf("fingerprintDevices does not work with firefox")
+	}
+	return nil
 }
 
 func ParseConfig(filePath string) *Config {
@@ -46,8 +261,18 @@ func ParseConfig(filePath string) *Config {
 		return nil
 	}
 
-	if !config.ParseFansCount && !config.ParseStoryLink {
-		log.Errorf("No parseFansCount and parseStoryLink found in config")
+	if !config.ParseProfile() && !config.ParseStoryLink {
+		log.Errorf("No parseFansCount, parseStoryLink or other profile fields found in config")
+		return nil
+	}
+
+	if _, err := NewScraperFactory(config.ScraperName()); err != nil {
+		log.Errorf("Invalid scraper in config, %v", err)
+		return nil
+	}
+
+	if err := config.validateBrowser(); err != nil {
+		log.Errorf("Invalid browser in config, %v", err)
 		return nil
 	}
 	return &config
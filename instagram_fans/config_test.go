@@ -6,3 +6,36 @@ func TestParseConfig(t *testing.T) {
 	config := ParseConfig("../config.json")
 	t.Logf("Config: %v", config)
 }
+
+func TestBrowserLaunchOptions(t *testing.T) {
+	config := &Config{BrowserArgs: []string{"--no-sandbox"}, BrowserExecutablePath: "/usr/bin/chromium", BrowserSlowMoMillis: 250}
+	options := browserLaunchOptions(config)
+	if !*options.Headless || *options.ExecutablePath != "/usr/bin/chromium" || *options.SlowMo != 250 || options.Args[0] != "--no-sandbox" {
+		t.Fatalf("unexpected launch options %+v", options)
+	}
+
+	config.ShowBrowser = true
+	if *browserLaunchOptions(config).Headless {
+		t.Fatalf("showBrowser should launch a visible browser")
+	}
+}
+
+func TestValidateBrowser(t *testing.T) {
+	cases := []struct {
+		config Config
+		valid  bool
+	}{
+		{Config{}, true},
+		{Config{BrowserEngine: BrowserWebkit}, true},
+		{Config{BrowserEngine: "edge"}, false},
+		{Config{BrowserCdpUrl: "http://127.0.0.1:9222"}, true},
+		{Config{BrowserEngine: BrowserFirefox, BrowserCdpUrl: "http://127.0.0.1:9222"}, false},
+		{Config{FingerprintDevices: []string{"iPhone 13"}}, true},
+		{Config{BrowserEngine: BrowserFirefox, FingerprintDevices: []string{"iPhone 13"}}, false},
+	}
+	for _, item := range cases {
+		if err := item.config.validateBrowser(); (err == nil) != item.valid {
+			t.Errorf("validateBrowser(%+v) got %v, want valid %v", item.config, err, item.valid)
+		}
+	}
+}
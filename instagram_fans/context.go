@@ -8,11 +8,24 @@ import (
 )
 
 type AppContext struct {
-	Pw          *playwright.Playwright
-	Db          *gorm.DB
-	AccountDb   *gorm.DB
+	Pw        *playwright.Playwright
+	Db        *gorm.DB
+	AccountDb *gorm.DB
+	Bloggers  BloggerStore
+	Accounts  AccountStore
+	// Proxies 账号绑定的代理, proxy 表为空时直接连接
+	Proxies ProxyStore
+	Cipher  *PasswordCipher
+	// Sessions 浏览器后端保存的账号登录状态
+	Sessions    *SessionStore
 	Config      *Config
 	MachineCode string
+	// Browsers 浏览器后端共享的浏览器池, http 后端时为 nil
+	Browsers *BrowserPool
+	// NewScraper 用账号创建并登录抓取后端, 由 config.Scraper 决定
+	NewScraper ScraperFactory
+	// LinkResolver 开启 resolveStoryLinks 时解析快拍外链的跳转, 否则为 nil
+	LinkResolver *LinkResolver
 }
 
 var (
@@ -21,9 +34,55 @@ var (
 	ErrorConnectDB        = errors.New("Can not connect to database!!!")
 	ErrorConnectAccountDB = errors.New("Can not connect to account database!!!")
 	ErrorPlayWrightStart  = errors.New("Can not start playwright!!!")
+	ErrorMigrate          = errors.New("Can not migrate database!!!")
+	ErrorAccountKey       = errors.New("Can not load account key!!!")
 )
 
 func InitContext() (*AppContext, error) {
+	appContext, err := InitDbContext()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := MigrateUp(appContext); err != nil {
+		log.Errorf("Can not migrate database, %v", err)
+		appContext.DestroyContext()
+		return nil, ErrorMigrate
+	}
+
+	newScraper, err := NewScraperFactory(appContext.Config.ScraperName())
+	if err != nil {
+		appContext.DestroyContext()
+		return nil, ErrorParseConfig
+	}
+	appContext.NewScraper = newScraper
+
+	if appContext.Config.ParseStoryLink && appContext.Config.ResolveStoryLinks {
+		config := appContext.Config
+		appContext.LinkResolver = NewLinkResolver(config.ResolveMaxHopCount(), config.ResolveTimeout(), config.ResolveDomainInterval())
+	}
+
+	// http 后端不需要浏览器
+	if appContext.Config.ScraperName() == ScraperBrowser {
+		pw, err := playwright.Run()
+		if err != nil {
+			appContext.DestroyContext()
+			return nil, ErrorPlayWrightStart
+		}
+		appContext.Pw = pw
+		appContext.Browsers = NewBrowserPool(func() (playwright.Browser, error) {
+			browser, err := NewBrowser(pw, appContext.Config)
+			if err != nil {
+				return nil, err
+			}
+			return *browser, nil
+		}, appContext.Config.BrowserContextLimit(), appContext.Config.BrowserMaxMemoryMB)
+	}
+	return appContext, nil
+}
+
+// InitDbContext 只读取配置并连接数据库, 不启动 playwright, 也不执行数据库变更
+func InitDbContext() (*AppContext, error) {
 
 	config := ParseConfig("config.json")
 	if config == nil {
@@ -36,24 +95,43 @@ func InitContext() (*AppContext, error) {
 	}
 	log.Infof("Machine code: %s", machineCode)
 
-	db, err := ConnectToDB(config.Dsn)
+	cipher, err := LoadPasswordCipher(AccountKeyEnv, config.AccountKeyFile)
+	if errors.Is(err, ErrNoAccountKey) {
+		log.Warnf("No account key configured, only plaintext passwords can be used")
+	} else if err != nil {
+		log.Errorf("Can not load account key, %v", err)
+		return nil, ErrorAccountKey
+	}
+
+	db, err := OpenDatabase(config.Driver, config.Dsn)
 	if err != nil {
+		log.Errorf("Can not connect to db, %v", err)
 		return nil, ErrorConnectDB
 	}
-	log.Infof("Connect to db(%s) success", config.Dsn)
+	log.Infof("Connect to db(%s) success", RedactDSN(config.Dsn))
 
-	accountDb, err := ConnectToDB(config.AccountDSN)
+	accountDb, err := OpenDatabase(config.AccountDriverName(), config.AccountDSN)
 	if err != nil {
+		log.Errorf("Can not connect to account db, %v", err)
+		SafeCloseDB(db)
 		return nil, ErrorConnectAccountDB
 	}
-	log.Infof("Connect to account db(%s) success", config.AccountDSN)
+	log.Infof("Connect to account db(%s) success", RedactDSN(config.AccountDSN))
 
-	pw, err := playwright.Run()
-	if err != nil {
-		return nil, ErrorPlayWrightStart
+	checkProxy := func(proxy *Proxy) error {
+		return CheckProxy(proxy, config.ProxyCheckAddress(), config.ProxyCheckTimeout())
+	}
+	appContext := AppContext{
+		Db:          db,
+		AccountDb:   accountDb,
+		Bloggers:    NewBloggerStore(db, config.Table, config.ScrapeAttemptLimit()),
+		Accounts:    NewAccountStore(accountDb, config.AccountTable, config.AccountCooldown(), cipher),
+		Proxies:     NewProxyStore(accountDb, config.AccountTable, config.ProxyCooldown(), cipher, checkProxy),
+		Cipher:      cipher,
+		Sessions:    NewSessionStore(config.SessionDirectory(), cipher),
+		Config:      config,
+		MachineCode: machineCode,
 	}
-
-	appContext := AppContext{Pw: pw, Db: db, AccountDb: accountDb, Config: config, MachineCode: machineCode}
 	return &appContext, nil
 }
 
@@ -64,8 +142,14 @@ func (appContext *AppContext) DestroyContext() {
 	}
 	if appContext.AccountDb != nil {
 		SafeCloseDB(appContext.AccountDb)
+		appContext.AccountDb = nil
 	}
 
+	// 先关闭浏览器再停止 playwright driver
+	if appContext.Browsers != nil {
+		appContext.Browsers.Close()
+		appContext.Browsers = nil
+	}
 	if appContext.Pw != nil {
 		err := appContext.Pw.Stop()
 		if err != nil {
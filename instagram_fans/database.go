@@ -3,25 +3,58 @@ package instagram_fans
 import (
 	"bufio"
 	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"math/rand"
 	"os"
+	"strings"
+	"time"
 )
 
-type Account struct {
-	Username    string `gorm:"column:user"`
-	Password    string `gorm:"column:psw"`
-	Status      int    `gorm:"column:status"`
-	MachineCode string `gorm:"column:Machine_code"`
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
+)
+
+// OpenDatabase 根据 driver 连接 MySQL 或本地 SQLite 文件
+func OpenDatabase(driver string, dsn string) (*gorm.DB, error) {
+	switch driver {
+	case "", DriverMySQL:
+		return ConnectToDB(dsn)
+	case DriverSQLite:
+		return ConnectToSQLite(dsn)
+	default:
+		return nil, errors.Errorf("unknown database driver %s", driver)
+	}
 }
 
 func ConnectToDB(dsn string) (*gorm.DB, error) {
 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
 	if err != nil {
-		log.Fatalf("Can not open db(%s), %v", dsn, err)
+		return nil, errors.Wrapf(err, "Can not open db(%s)", RedactDSN(dsn))
+	}
+	return db, nil
+}
+
+// ConnectToSQLite 打开本地 SQLite 文件。SQLite 没有行锁, 所有写操作通过单个连接串行执行
+func ConnectToSQLite(path string) (*gorm.DB, error) {
+	dsn := path
+	if !strings.Contains(dsn, "?") {
+		dsn += "?_busy_timeout=5000&_txlock=immediate"
+	}
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can not open sqlite(%s)", path)
 	}
-	return db, err
+	sqlDb, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDb.SetMaxOpenConns(1)
+	return db, nil
 }
 
 func SafeCloseDB(db *gorm.DB) {
@@ -36,7 +69,7 @@ func SafeCloseDB(db *gorm.DB) {
 
 func UsableAccountCount(db *gorm.DB, table string) int {
 	var count int64
-	result := db.Table(table).Where("status = 0").Count(&count)
+	result := db.Table(table).Where("status = ?", AccountStatusIdle).Count(&count)
 	if result.Error != nil {
 		log.Errorf("Can not get account count, %v", result.Error)
 		return 0
@@ -45,15 +78,78 @@ func UsableAccountCount(db *gorm.DB, table string) int {
 }
 
 func MakAccountUsable(db *gorm.DB, table string, machineCode string) {
-	result := db.Table(table).Where("Machine_code = ?", machineCode).Where("status = ?", 1).Updates(map[string]interface{}{"status": 0})
-	if result.Error != nil {
-		log.Errorf("Can not update account status, %v", result.Error)
+	where := func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("Machine_code = ?", machineCode).Where("status = ?", AccountStatusInUse)
+	}
+	change := AccountStatusChange{Status: AccountStatusIdle, Reason: AccountReasonReleased, MachineCode: machineCode}
+	if _, err := changeAccountsStatus(db, table, where, change); err != nil {
+		log.Errorf("Can not update account status, %v", err)
+	}
+}
+
+// ReviveCooledDownAccounts 把进入 unusable 状态超过 cooldown 的账号恢复为空闲
+func ReviveCooledDownAccounts(db *gorm.DB, table string, cooldown time.Duration) {
+	deadline := time.Now().Add(-cooldown)
+	where := func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("status = ?", AccountStatusUnusable).
+			Where("status_changed_at IS NOT NULL AND status_changed_at < ?", deadline)
 	}
+	change := AccountStatusChange{Status: AccountStatusIdle, Reason: AccountReasonCooldownExpired}
+	count, err := changeAccountsStatus(db, table, where, change)
+	if err != nil {
+		log.Errorf("Can not revive cooled down accounts, %v", err)
+		return
+	}
+	if count > 0 {
+		log.Infof("revive %d accounts after cooldown(%v)", count, cooldown)
+	}
+}
+
+// changeAccountsStatus 修改 where 选中的所有账号的状态, 并为每个真正修改了的账号写一条审计记录。
+// 在事务里锁定账号后再修改, 修改时重复 where 条件, 期间被其他机器改掉状态的账号不会被覆盖。
+// change.MachineCode 为空时保留账号原来的机器码
+func changeAccountsStatus(db *gorm.DB, table string, where func(tx *gorm.DB) *gorm.DB, change AccountStatusChange) (int, error) {
+	changed := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var accounts []*Account
+		if err := where(tx.Table(table)).Clauses(clause.Locking{Strength: "UPDATE"}).Find(&accounts).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		updates := accountStatusUpdates(change, now)
+		for _, account := range accounts {
+			result := where(tx.Table(table).Where("user = ?", account.Username)).Updates(updates)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			if err := tx.Create(newAccountAudit(account, change, now)).Error; err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// accountStatusUpdates 修改账号状态时写入的列, MachineCode 为空时不修改机器码
+func accountStatusUpdates(change AccountStatusChange, now time.Time) map[string]interface{} {
+	updates := map[string]interface{}{"status": change.Status, "status_reason": change.Reason, "status_changed_at": now}
+	if change.MachineCode != "" {
+		updates["Machine_code"] = change.MachineCode
+	}
+	return updates
 }
 
-func FindAccount(db *gorm.DB, table string, machineCode string) *Account {
+func FindAccount(db *gorm.DB, table string, machineCode string, cipher *PasswordCipher) *Account {
 	var accounts []*Account
-	result := db.Table(table).Where("status = 0").Order("id ASC").Find(&accounts)
+	result := db.Table(table).Where("status = ?", AccountStatusIdle).Order("id ASC").Find(&accounts)
 	if result.Error != nil {
 		log.Errorf("Can not find account, %v", result.Error)
 		return nil
@@ -74,86 +170,410 @@ func FindAccount(db *gorm.DB, table string, machineCode string) *Account {
 		index := rand.Intn(len(accounts))
 		account = accounts[index]
 	}
+	account.cipher = cipher
 	return account
 }
 
-func MarkAccountStatus(db *gorm.DB, table string, account *Account, status int, machineCode string) {
-	log.Infof("MarkAccountStatus %s to %d", account.Username, status)
-	result := db.Table(table).Where("user = ?", account.Username).Updates(map[string]interface{}{"status": status, "Machine_code": machineCode})
+// RekeyAccountPasswords 用 newCipher 重新加密所有账号密码和 Cookie, 明文也会被加密, 返回处理的账号数
+func RekeyAccountPasswords(db *gorm.DB, table string, oldCipher *PasswordCipher, newCipher *PasswordCipher) (int, error) {
+	count := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var accounts []*Account
+		if err := tx.Table(table).Find(&accounts).Error; err != nil {
+			return err
+		}
+		for _, account := range accounts {
+			updates := make(map[string]interface{})
+			for column, stored := range map[string]Secret{"psw": account.Password, "session_cookie": account.SessionCookie} {
+				if stored == "" {
+					continue
+				}
+				plain, err := oldCipher.Decrypt(string(stored))
+				if err != nil {
+					return errors.Wrapf(err, "account %s %s", account.Username, column)
+				}
+				encrypted, err := newCipher.Encrypt(plain)
+				if err != nil {
+					return err
+				}
+				updates[column] = encrypted
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			if err := tx.Table(table).Where("user = ?", account.Username).Updates(updates).Error; err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// SetAccountSessionCookie 保存账号的 Cookie 请求头, 配置了密钥时加密保存
+func SetAccountSessionCookie(db *gorm.DB, table string, username string, cookie string, cipher *PasswordCipher) error {
+	stored := cookie
+	if cipher != nil {
+		encrypted, err := cipher.Encrypt(cookie)
+		if err != nil {
+			return err
+		}
+		stored = encrypted
+	}
+	result := db.Table(table).Where("user = ?", username).Update("session_cookie", stored)
 	if result.Error != nil {
-		log.Errorf("Can not update account status, %v", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.Errorf("account %s not found", username)
 	}
+	return nil
 }
 
-func SetAccountMachineCode(db *gorm.DB, table string, account *Account, machineCode string) {
-	result := db.Table(table).Where("user = ?", account.Username).Updates(map[string]interface{}{"Machine_code": machineCode})
+// SetAccountFingerprint 保存账号的浏览器指纹, fingerprint 为 nil 时清除, 下次使用时重新生成
+func SetAccountFingerprint(db *gorm.DB, table string, username string, fingerprint *Fingerprint) error {
+	var value interface{}
+	if fingerprint != nil {
+		value = *fingerprint
+	}
+	result := db.Table(table).Where("user = ?", username).Update("fingerprint", value)
 	if result.Error != nil {
-		log.Errorf("Can not update account status, %v", result.Error)
+		return result.Error
 	}
+	if result.RowsAffected == 0 {
+		return errors.Errorf("account %s not found", username)
+	}
+	return nil
 }
 
-func FindBloger(db *gorm.DB, table string, limit int, low int) ([]*User, error) {
+func MarkAccountStatus(db *gorm.DB, table string, account *Account, change AccountStatusChange) {
+	log.Infof("MarkAccountStatus %s to %s(%s)", account.Username, change.Status, change.Reason)
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var current Account
+		if err := tx.Table(table).Clauses(clause.Locking{Strength: "UPDATE"}).Where("user = ?", account.Username).First(&current).Error; err != nil {
+			return err
+		}
+		result := tx.Table(table).Where("user = ?", account.Username).Updates(accountStatusUpdates(change, now))
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Create(newAccountAudit(&current, change, now)).Error
+	})
+	if err != nil {
+		log.Errorf("Can not update account status, %v", err)
+		return
+	}
+	account.Status = change.Status
+	account.StatusReason = change.Reason
+	account.StatusChangedAt = &now
+	if change.MachineCode != "" {
+		account.MachineCode = change.MachineCode
+	}
+}
+
+func SetAccountMachineCode(db *gorm.DB, table string, account *Account, machineCode string) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var current Account
+		if err := tx.Table(table).Where("user = ?", account.Username).First(&current).Error; err != nil {
+			return err
+		}
+		result := tx.Table(table).Where("user = ?", account.Username).Updates(map[string]interface{}{"Machine_code": machineCode})
+		if result.Error != nil {
+			return result.Error
+		}
+		change := AccountStatusChange{Status: current.Status, Reason: AccountReasonMachineChanged, MachineCode: machineCode}
+		return tx.Create(newAccountAudit(&current, change, time.Now())).Error
+	})
+	if err != nil {
+		log.Errorf("Can not update account status, %v", err)
+		return
+	}
+	account.MachineCode = machineCode
+}
+
+// FindBloger 在一个事务里锁定并领取最多 limit 个待抓取的博主, 领取前先回收租约已过期的博主。
+// SKIP LOCKED 保证多台机器、多个 goroutine 同时领取时不会拿到同一个博主
+func FindBloger(db *gorm.DB, table string, limit int, machineCode string, lease time.Duration) ([]*User, error) {
+	ReclaimExpiredLeases(db, table)
 
 	var users []*User
-	db.Table(table).Where("fans_count = -1").Where("id > ?", low).Order("id ASC").Limit(limit).Find(&users)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Table(table).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("scrape_status = ?", ScrapeStatusPending).
+			Order("id ASC").
+			Limit(limit).
+			Find(&users)
+		if result.Error != nil {
+			return result.Error
+		}
+		if len(users) == 0 {
+			return nil
+		}
+		return MarkUserStatusIsWorking(users, tx, table, machineCode, lease)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Can not claim bloggers")
+	}
 	return users, nil
 }
 
-func MarkUserStatusIsWorking(users []*User, db *gorm.DB, table string) {
-	begin := users[0].Id
-	end := users[len(users)-1].Id
-	log.Infof("has %d to handle, from %d to %d", len(users), begin, end)
-	db.Table(table).
-		Where("id >= ? and id <= ?", begin, end).
-		Where("fans_count = -1").
-		Updates(map[string]interface{}{"fans_count": -2})
+// ReclaimExpiredLeases 把租约过期的博主放回队列, 对应的 worker 可能已经崩溃
+func ReclaimExpiredLeases(db *gorm.DB, table string) {
+	result := db.Table(table).
+		Where("scrape_status = ?", ScrapeStatusClaimed).
+		Where("lease_expires_at IS NULL OR lease_expires_at < ?", time.Now()).
+		Updates(map[string]interface{}{"scrape_status": ScrapeStatusPending, "lease_expires_at": nil})
+	if result.Error != nil {
+		log.Errorf("Can not reclaim expired leases, %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Infof("reclaim %d bloggers with expired lease", result.RowsAffected)
+	}
+}
+
+// transitionBloggers 把 ids 中状态属于 from 的博主改为 to, 离开 claimed 状态时清除租约
+func transitionBloggers(db *gorm.DB, table string, ids []int, from []ScrapeStatus, to ScrapeStatus) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	result := db.Table(table).
+		Where("id IN ?", ids).
+		Where("scrape_status IN ?", from).
+		Updates(map[string]interface{}{"scrape_status": to, "lease_expires_at": nil})
+	if result.Error != nil {
+		log.Errorf("Can not change scrape status of %v to %s, %v", ids, to, result.Error)
+		return result.Error
+	}
+	return nil
+}
+
+func MarkUserStatusIsWorking(users []*User, db *gorm.DB, table string, machineCode string, lease time.Duration) error {
+	ids := make([]int, len(users))
+	for idx, user := range users {
+		ids[idx] = user.Id
+		user.ScrapeStatus = ScrapeStatusClaimed
+		user.ClaimedBy = machineCode
+	}
+	log.Infof("has %d to handle, from %d to %d", len(users), ids[0], ids[len(ids)-1])
+	result := db.Table(table).
+		Where("id IN ?", ids).
+		Where("scrape_status = ?", ScrapeStatusPending).
+		Updates(map[string]interface{}{
+			"scrape_status":    ScrapeStatusClaimed,
+			"claimed_by":       machineCode,
+			"lease_expires_at": time.Now().Add(lease),
+		})
+	if result.Error != nil {
+		log.Errorf("Can not claim bloggers %v, %v", ids, result.Error)
+	}
+	return result.Error
+}
+
+// RenewBloggerLease 延长本机领取的博主的租约
+func RenewBloggerLease(ids []int, db *gorm.DB, table string, machineCode string, lease time.Duration) {
+	if len(ids) == 0 {
+		return
+	}
+	result := db.Table(table).
+		Where("id IN ?", ids).
+		Where("scrape_status = ?", ScrapeStatusClaimed).
+		Where("claimed_by = ?", machineCode).
+		Updates(map[string]interface{}{"lease_expires_at": time.Now().Add(lease)})
+	if result.Error != nil {
+		log.Errorf("Can not renew lease for %v, %v", ids, result.Error)
+	}
 }
 
-func MarkUserStatusIdle(begin, end int, db *gorm.DB, table string) {
-	log.Infof("revoke status to -1 , from %d to %d", begin, end)
-	db.Table(table).
-		Where("id >= ? and id <= ?", begin, end).
-		Where("fans_count = -2").
-		Updates(map[string]interface{}{"fans_count": -1})
+func MarkUserStatusIdle(ids []int, db *gorm.DB, table string) {
+	log.Infof("revoke status to %s for %v", ScrapeStatusPending, ids)
+	transitionBloggers(db, table, ids, []ScrapeStatus{ScrapeStatusClaimed}, ScrapeStatusPending)
 }
 
-func UpdateSingleDataToDb(user *User, appContext *AppContext) {
-	if !appContext.Config.ParseFansCount && !appContext.Config.ParseStoryLink {
-		log.Errorf("No parseFansCount and parseStoryLink found in config")
+// MarkUserScrapeStatus 记录已领取博主的抓取结果并结束抓取, detail 记录没有抓到数据的原因
+func MarkUserScrapeStatus(user *User, status ScrapeStatus, detail string, db *gorm.DB, table string) {
+	log.Infof("mark user(%s) scrape status to %s (%s)", user.Url, status, detail)
+	detail = truncateScrapeDetail(detail)
+	updates := map[string]interface{}{"scrape_status": status, "scrape_detail": detail, "lease_expires_at": nil}
+	// 会被重新排队的状态记一次失败, 达到上限后不再排队
+	retry := status == ScrapeStatusFailed || status == ScrapeStatusUnavailable
+	if retry {
+		updates["scrape_attempts"] = gorm.Expr("scrape_attempts + 1")
+	}
+	result := db.Table(table).
+		Where("id = ?", user.Id).
+		Where("scrape_status = ?", ScrapeStatusClaimed).
+		Where("claimed_by = ?", user.ClaimedBy).
+		Updates(updates)
+	if result.Error != nil {
+		log.Errorf("Can not change scrape status of %d to %s, %v", user.Id, status, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		log.Warnf("user(%s) is no longer claimed by %s, skip marking it %s", user.Url, user.ClaimedBy, status)
 		return
 	}
-	db := appContext.Db
-	table := appContext.Config.Table
+	user.ScrapeStatus = status
+	user.ScrapeDetail = detail
+	if retry {
+		user.ScrapeAttempts++
+	}
+}
+
+// truncateScrapeDetail 截断到 scrape_detail 列的长度
+func truncateScrapeDetail(detail string) string {
+	runes := []rune(detail)
+	if len(runes) > 255 {
+		return string(runes[:255])
+	}
+	return detail
+}
 
-	if appContext.Config.ParseFansCount && appContext.Config.ParseStoryLink {
-		if user.FansCount == -2 && user.StoryLink == "" {
-			log.Errorf("No fans count and story link found in user(%s)", user.Url)
-			return
+// RequeueFailedBloggers 把抓取失败和主页暂时打不开的博主重新放回队列, 连续失败 maxAttempts 次的不再排队。
+// 不存在、私密和受限的主页重试也没有结果, 保持原状态
+func RequeueFailedBloggers(db *gorm.DB, table string, maxAttempts int) {
+	failed := []ScrapeStatus{ScrapeStatusFailed, ScrapeStatusUnavailable}
+	result := db.Table(table).
+		Where("scrape_status IN ?", failed).
+		Where("scrape_attempts < ?", maxAttempts).
+		Updates(map[string]interface{}{"scrape_status": ScrapeStatusPending})
+	if result.Error != nil {
+		log.Errorf("Can not requeue failed bloggers, %v", result.Error)
+		return
+	}
+	var exhausted int64
+	db.Table(table).Where("scrape_status IN ?", failed).Where("scrape_attempts >= ?", maxAttempts).Count(&exhausted)
+	log.Infof("requeue %d failed bloggers, %d reached %d attempts and stay failed", result.RowsAffected, exhausted, maxAttempts)
+}
+
+// errBloggerLeaseLost 写入抓取结果时博主已经不再由本机领取
+var errBloggerLeaseLost = errors.New("blogger lease lost")
+
+// UpdateSingleDataToDb 更新博主的最新数据, 同时在 blogger_snapshot 里追加一条本次抓取的快照
+func UpdateSingleDataToDb(user *User, account string, machineCode string, config *Config, db *gorm.DB, table string) {
+	if !config.ParseProfile() && !config.ParseStoryLink {
+		log.Errorf("No parseFansCount, parseStoryLink or other profile fields found in config")
+		return
+	}
+
+	updates := make(map[string]interface{})
+	snapshot := BloggerSnapshot{UserId: user.Id, Account: account, MachineCode: machineCode}
+	found := false
+
+	if config.ParseFansCount && user.FansCount != -2 {
+		updates["fans_count"] = user.FansCount
+		updates["fans_count_exact"] = user.FansCountExact
+		snapshot.FansCount = &user.FansCount
+		snapshot.FansCountExact = &user.FansCountExact
+		found = true
+	}
+	if config.ParseStoryLink {
+		updates["story_link"] = user.StoryLink
+		snapshot.StoryLink = &user.StoryLink
+		found = found || user.StoryLink != ""
+	}
+	if addProfileDetailUpdates(updates, user, config) {
+		found = true
+	}
+	if !found {
+		log.Errorf("No fans count, story link or profile data found in user(%s)", user.Url)
+		MarkUserScrapeStatus(user, ScrapeStatusFailed, "no data found in page", db, table)
+		return
+	}
+	updates["scrape_status"] = ScrapeStatusDone
+	updates["scrape_detail"] = nil
+	updates["scrape_attempts"] = 0
+	if user.Private {
+		// 私密账号只能拿到主页上的数量和资料
+		updates["scrape_status"] = ScrapeStatusPrivate
+		updates["scrape_detail"] = ErrProfilePrivate.Error()
+	}
+
+	var storyLinks []*BloggerStoryLink
+	if config.ParseStoryLink {
+		storyLinks = storyLinksFromItems(user.Id, user.Stories, time.Now())
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 租约过期后博主可能已被其他机器领取, 只写本机仍然持有的博主, 否则连同快照一起放弃
+		result := tx.Table(table).
+			Where("url = ?", user.Url).
+			Where("scrape_status = ?", ScrapeStatusClaimed).
+			Where("claimed_by = ?", machineCode).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
 		}
-		db.Table(table).Where("url = ?", user.Url).Updates(map[string]interface{}{"story_link": user.StoryLink, "fans_count": user.FansCount})
-		// execStr := fmt.Sprintf("UPDATE %s SET story_link = ?, fans_count = ? WHERE url = ?", table)
-		// _, err = db.Exec(execStr, user.StoryLink, user.FansCount, user.Url)
-	} else if appContext.Config.ParseFansCount && !appContext.Config.ParseStoryLink {
-		if user.FansCount == -2 {
-			log.Errorf("No fans count found in user(%s)", user.Url)
-			return
+		if result.RowsAffected == 0 {
+			return errBloggerLeaseLost
 		}
-		db.Table(table).Where("url = ?", user.Url).Updates(map[string]interface{}{"fans_count": user.FansCount})
-		// execStr := fmt.Sprintf("UPDATE %s SET fans_count = ? WHERE url = ?", table)
-		// _, err = db.Exec(execStr, user.FansCount, user.Url)
-	} else if !appContext.Config.ParseFansCount && appContext.Config.ParseStoryLink {
-		if user.StoryLink == "" {
-			log.Errorf("No story link found in user(%s)", user.Url)
-			return
+		if err := upsertStoryLinks(tx, storyLinks); err != nil {
+			return err
 		}
-		db.Table(table).Where("url = ?", user.Url).Updates(map[string]interface{}{"story_link": user.StoryLink})
-		// execStr := fmt.Sprintf("UPDATE %s SET story_link = ? WHERE url = ?", table)
-		// _, err = db.Exec(execStr, user.StoryLink, user.Url)
+		return tx.Create(&snapshot).Error
+	})
+	if errors.Is(err, errBloggerLeaseLost) {
+		log.Warnf("user(%s) is no longer claimed by %s, drop the scraped data", user.Url, machineCode)
+		return
+	}
+	if err != nil {
+		log.Errorf("Can not update user(%s), %v", user.Url, err)
+		return
 	}
 
 	log.Printf("update user(%s) count %d, link: %s success", user.Url, user.FansCount, user.StoryLink)
 }
 
+// addProfileDetailUpdates 按配置把粉丝数以外的主页资料加入 updates, 有任何一项写入时返回 true
+func addProfileDetailUpdates(updates map[string]interface{}, user *User, config *Config) bool {
+	found := false
+	if config.ParseFollowingCount && user.FollowingCount != -2 {
+		updates["following_count"] = user.FollowingCount
+		found = true
+	}
+	if config.ParsePostCount && user.PostCount != -2 {
+		updates["post_count"] = user.PostCount
+		found = true
+	}
+	details := user.Details
+	if details == nil {
+		return found
+	}
+	// 从 meta/DOM 补出来的资料, 没有找到的字段不写, 以免清掉之前抓到的值
+	partial := details.Partial
+	if config.ParseFullName && (!partial || details.FullName != "") {
+		updates["full_name"] = details.FullName
+		found = true
+	}
+	if config.ParseBiography && (!partial || details.Biography != "") {
+		updates["biography"] = details.Biography
+		found = true
+	}
+	if config.ParseExternalUrl && (!partial || details.ExternalUrl != "") {
+		updates["external_url"] = details.ExternalUrl
+		found = true
+	}
+	if partial {
+		return found
+	}
+	if config.ParseVerified {
+		updates["is_verified"] = details.IsVerified
+		found = true
+	}
+	if config.ParseCategory {
+		updates["category"] = details.Category
+		found = true
+	}
+	if config.ParsePrivate {
+		updates["is_private"] = details.IsPrivate
+		found = true
+	}
+	return found
+}
+
 func InsertFilesToDb(path string, dsn string) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -0,0 +1,572 @@
+package instagram_fans
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestContext(t *testing.T) *AppContext {
+	t.Helper()
+	db, err := OpenDatabase(DriverSQLite, filepath.Join(t.TempDir(), "instagram.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { SafeCloseDB(db) })
+
+	config := &Config{Table: "user", AccountTable: "users", ParseFansCount: true, ParseStoryLink: true}
+	appContext := &AppContext{
+		Db:          db,
+		AccountDb:   db,
+		Bloggers:    NewBloggerStore(db, config.Table, config.ScrapeAttemptLimit()),
+		Accounts:    NewAccountStore(db, config.AccountTable, config.AccountCooldown(), nil),
+		Config:      config,
+		MachineCode: "machine-a",
+	}
+	if err := MigrateUp(appContext); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return appContext
+}
+
+func insertBloggers(t *testing.T, appContext *AppContext, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		user := User{Url: fmt.Sprintf("https://www.instagram.com/blogger_%d", i)}
+		if err := appContext.Db.Table(appContext.Config.Table).Create(&user).Error; err != nil {
+			t.Fatalf("insert blogger: %v", err)
+		}
+	}
+}
+
+func TestOpenDatabaseReturnsMySQLError(t *testing.T) {
+	// 打不开 MySQL 时与 SQLite 一样返回错误, 不直接退出进程, 错误里不带密码
+	_, err := OpenDatabase(DriverMySQL, "root:secret@tcp(127.0.0.1:1)/instagram")
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("bad dsn got %v", err)
+	}
+}
+
+func TestMigrateDownRollsBackMigrations(t *testing.T) {
+	appContext := newTestContext(t)
+	migrator := appContext.Db.Migrator()
+	if !migrator.HasTable(BloggerSnapshot{}.TableName()) {
+		t.Fatalf("blogger_snapshot should exist after migrate up")
+	}
+
+	// 依次回滚到 create_blogger_snapshot 之前
+	for i := len(bloggerMigrations); i > 4; i-- {
+		if err := MigrateDown(appContext, MigrationScopeBlogger); err != nil {
+			t.Fatalf("migrate down: %v", err)
+		}
+	}
+	if migrator.HasTable(BloggerSnapshot{}.TableName()) {
+		t.Fatalf("blogger_snapshot should be dropped after migrate down")
+	}
+	if migrator.HasColumn(appContext.Config.Table, "fans_count_exact") {
+		t.Fatalf("fans_count_exact should be dropped after migrate down")
+	}
+
+	if err := MigrateUp(appContext); err != nil {
+		t.Fatalf("migrate up again: %v", err)
+	}
+	if !migrator.HasTable(BloggerSnapshot{}.TableName()) {
+		t.Fatalf("blogger_snapshot should be recreated")
+	}
+}
+
+func TestMigrateDownRollsBackAllAccountMigrations(t *testing.T) {
+	appContext := newTestContext(t)
+	for range accountMigrations {
+		if err := MigrateDown(appContext, MigrationScopeAccount); err != nil {
+			t.Fatalf("migrate down: %v", err)
+		}
+	}
+	if appContext.AccountDb.Migrator().HasTable(appContext.Config.AccountTable) {
+		t.Fatalf("account table should be dropped after rolling back every migration")
+	}
+	if err := MigrateUp(appContext); err != nil {
+		t.Fatalf("migrate up again: %v", err)
+	}
+}
+
+func TestMigrateDownBreaksTiesByScopeOrder(t *testing.T) {
+	appContext := newTestContext(t)
+	// MySQL 的 applied_at 只精确到秒, 两个库的最后一次变更可能同时执行
+	at := time.Now().Truncate(time.Second)
+	appContext.Db.Model(&SchemaMigration{}).Where("1 = 1").Update("applied_at", at)
+
+	if err := MigrateDown(appContext, ""); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	applied, _ := MigrationStatus(appContext)
+	count := map[string]int{}
+	for _, item := range applied {
+		count[item.Scope]++
+	}
+	if count[MigrationScopeBlogger] != len(bloggerMigrations) || count[MigrationScopeAccount] != len(accountMigrations)-1 {
+		t.Fatalf("the account migration applied last should be rolled back first, got %v", count)
+	}
+}
+
+func TestMigrationUpsCanBeRerun(t *testing.T) {
+	appContext := newTestContext(t)
+	insertBloggers(t, appContext, 2)
+	table := appContext.Config.Table
+	appContext.Db.Table(table).Where("id = ?", 1).Updates(map[string]interface{}{"story_link": "https://a.com,https://b.com", "fans_count": 10, "scrape_status": ScrapeStatusPending})
+
+	// MySQL 上 DDL 不能回滚, 中途失败后会重新执行同一个 Up, 结果必须和执行一次相同
+	for _, scope := range migrationScopes(appContext) {
+		for _, migration := range scope.migrations {
+			for i := 0; i < 2; i++ {
+				if err := migration.Up(scope.db, scope.table); err != nil {
+					t.Fatalf("rerun %s %d_%s: %v", scope.name, migration.Version, migration.Name, err)
+				}
+			}
+		}
+	}
+	links, err := appContext.Bloggers.FindStoryLinks(1)
+	if err != nil || len(links) != 2 {
+		t.Fatalf("got %d story links after rerun, err %v", len(links), err)
+	}
+	var statuses []ScrapeStatus
+	appContext.Db.Table(table).Order("id").Pluck("scrape_status", &statuses)
+	if len(statuses) != 2 || statuses[0] != ScrapeStatusDone || statuses[1] != ScrapeStatusPending {
+		t.Fatalf("scrape_status backfill got %v", statuses)
+	}
+}
+
+func TestFindBlogerClaimsEachBloggerOnce(t *testing.T) {
+	appContext := newTestContext(t)
+	insertBloggers(t, appContext, 25)
+
+	var mutex sync.Mutex
+	claimed := make(map[int]string)
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(machineCode string) {
+			defer wg.Done()
+			for {
+				users, err := appContext.Bloggers.FindBloger(3, machineCode, time.Minute)
+				if err != nil {
+					t.Errorf("find bloger: %v", err)
+					return
+				}
+				if len(users) == 0 {
+					return
+				}
+				mutex.Lock()
+				for _, user := range users {
+					if owner, ok := claimed[user.Id]; ok {
+						t.Errorf("blogger %d claimed by %s and %s", user.Id, owner, machineCode)
+					}
+					claimed[user.Id] = machineCode
+				}
+				mutex.Unlock()
+			}
+		}(fmt.Sprintf("machine-%d", worker))
+	}
+	wg.Wait()
+
+	if len(claimed) != 25 {
+		t.Fatalf("claimed %d bloggers, want 25", len(claimed))
+	}
+}
+
+func TestFindBlogerReclaimsExpiredLease(t *testing.T) {
+	appContext := newTestContext(t)
+	insertBloggers(t, appContext, 2)
+
+	users, err := appContext.Bloggers.FindBloger(10, "crashed", -time.Second)
+	if err != nil || len(users) != 2 {
+		t.Fatalf("first claim got %d users, err %v", len(users), err)
+	}
+
+	users, err = appContext.Bloggers.FindBloger(10, "machine-b", time.Minute)
+	if err != nil || len(users) != 2 {
+		t.Fatalf("expired lease should be reclaimed, got %d users, err %v", len(users), err)
+	}
+	if users[0].ClaimedBy != "machine-b" {
+		t.Fatalf("claimed by %s, want machine-b", users[0].ClaimedBy)
+	}
+
+	users, err = appContext.Bloggers.FindBloger(10, "machine-c", time.Minute)
+	if err != nil || len(users) != 0 {
+		t.Fatalf("live lease must not be reclaimed, got %d users, err %v", len(users), err)
+	}
+}
+
+func TestRequeueFailedBloggersStopsAfterMaxAttempts(t *testing.T) {
+	appContext := newTestContext(t)
+	insertBloggers(t, appContext, 1)
+	table := appContext.Db.Table(appContext.Config.Table).Session(&gorm.Session{})
+	maxAttempts := appContext.Config.ScrapeAttemptLimit()
+
+	claim := func() *User {
+		t.Helper()
+		users, err := appContext.Bloggers.FindBloger(1, appContext.MachineCode, time.Minute)
+		if err != nil || len(users) != 1 {
+			t.Fatalf("claim got %d users, err %v", len(users), err)
+		}
+		return users[0]
+	}
+	var saved User
+	// claimed -> unavailable/failed -> pending, 每次失败记一次
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		status := ScrapeStatusUnavailable
+		if attempt%2 == 0 {
+			status = ScrapeStatusFailed
+		}
+		appContext.Bloggers.MarkUserScrapeStatus(claim(), status, "page is unavailable")
+		appContext.Bloggers.RequeueFailedBloggers()
+		table.First(&saved)
+		if saved.ScrapeAttempts != attempt {
+			t.Fatalf("attempt %d recorded %d attempts", attempt, saved.ScrapeAttempts)
+		}
+		if attempt < maxAttempts && saved.ScrapeStatus != ScrapeStatusPending {
+			t.Fatalf("attempt %d should be requeued, got %s", attempt, saved.ScrapeStatus)
+		}
+	}
+	// 达到上限后保持失败状态
+	if saved.ScrapeStatus == ScrapeStatusPending {
+		t.Fatalf("blogger failed %d times should not be requeued", maxAttempts)
+	}
+
+	// 抓取成功后清零, 不存在的主页不计入重试
+	table.Where("id = ?", saved.Id).Updates(map[string]interface{}{"scrape_status": ScrapeStatusPending, "scrape_attempts": 1})
+	user := claim()
+	user.FansCount = 100
+	appContext.Bloggers.UpdateSingleDataToDb(user, "account", appContext.MachineCode, appContext.Config)
+	table.First(&saved)
+	if saved.ScrapeStatus != ScrapeStatusDone || saved.ScrapeAttempts != 0 {
+		t.Fatalf("successful scrape should reset attempts, got %s %d", saved.ScrapeStatus, saved.ScrapeAttempts)
+	}
+	table.Where("id = ?", saved.Id).Update("scrape_status", ScrapeStatusPending)
+	appContext.Bloggers.MarkUserScrapeStatus(claim(), ScrapeStatusNotFound, "profile not found")
+	appContext.Bloggers.RequeueFailedBloggers()
+	table.First(&saved)
+	if saved.ScrapeStatus != ScrapeStatusNotFound || saved.ScrapeAttempts != 0 {
+		t.Fatalf("not found blogger got %s %d", saved.ScrapeStatus, saved.ScrapeAttempts)
+	}
+}
+
+func TestUpdateSingleDataToDbAppendsSnapshot(t *testing.T) {
+	appContext := newTestContext(t)
+	insertBloggers(t, appContext, 1)
+
+	for _, fansCount := range []int{100, 120} {
+		users, err := appContext.Bloggers.FindBloger(1, appContext.MachineCode, time.Minute)
+		if err != nil || len(users) != 1 {
+			t.Fatalf("claim got %d users, err %v", len(users), err)
+		}
+		user := users[0]
+		user.FansCount = fansCount
+		user.FansCountExact = fansCount == 120
+		user.StoryLink = "https://example.com"
+		appContext.Bloggers.UpdateSingleDataToDb(user, "account", appContext.MachineCode, appContext.Config)
+		appContext.Db.Table(appContext.Config.Table).Where("id = ?", user.Id).Update("scrape_status", ScrapeStatusPending)
+	}
+
+	var user User
+	appContext.Db.Table(appContext.Config.Table).First(&user)
+	if user.FansCount != 120 || !user.FansCountExact {
+		t.Fatalf("latest fans_count %d exact %v, want exact 120", user.FansCount, user.FansCountExact)
+	}
+
+	snapshots, err := appContext.Bloggers.FindBloggerSnapshots(user.Id, 0)
+	if err != nil {
+		t.Fatalf("find snapshots: %v", err)
+	}
+	if len(snapshots) != 2 || *snapshots[0].FansCount != 120 || *snapshots[1].FansCount != 100 || !*snapshots[0].FansCountExact || *snapshots[1].FansCountExact {
+		t.Fatalf("unexpected snapshots %+v", snapshots)
+	}
+	if snapshots[0].Account != "account" || snapshots[0].MachineCode != appContext.MachineCode {
+		t.Fatalf("snapshot should record account and machine code, got %+v", snapshots[0])
+	}
+}
+
+func TestUpdateSingleDataToDbSkipsLostLease(t *testing.T) {
+	appContext := newTestContext(t)
+	insertBloggers(t, appContext, 1)
+
+	stale, err := appContext.Bloggers.FindBloger(1, "crashed", -time.Second)
+	if err != nil || len(stale) != 1 {
+		t.Fatalf("first claim got %d users, err %v", len(stale), err)
+	}
+	users, err := appContext.Bloggers.FindBloger(1, appContext.MachineCode, time.Minute)
+	if err != nil || len(users) != 1 {
+		t.Fatalf("reclaim got %d users, err %v", len(users), err)
+	}
+
+	// 租约过期的机器写回结果时不能覆盖新领取者的状态, 也不追加快照
+	stale[0].FansCount = 100
+	appContext.Bloggers.UpdateSingleDataToDb(stale[0], "account", "crashed", appContext.Config)
+	appContext.Bloggers.MarkUserScrapeStatus(stale[0], ScrapeStatusFailed, "late failure")
+
+	var saved User
+	appContext.Db.Table(appContext.Config.Table).First(&saved)
+	if saved.ScrapeStatus != ScrapeStatusClaimed || saved.ClaimedBy != appContext.MachineCode || saved.FansCount != -1 {
+		t.Fatalf("stale writer changed the blogger, got %+v", saved)
+	}
+	if snapshots, _ := appContext.Bloggers.FindBloggerSnapshots(saved.Id, 0); len(snapshots) != 0 {
+		t.Fatalf("stale writer appended snapshots %+v", snapshots)
+	}
+
+	users[0].FansCount = 120
+	appContext.Bloggers.UpdateSingleDataToDb(users[0], "account", appContext.MachineCode, appContext.Config)
+	appContext.Db.Table(appContext.Config.Table).First(&saved)
+	if saved.ScrapeStatus != ScrapeStatusDone || saved.FansCount != 120 {
+		t.Fatalf("current claimer should write the result, got %+v", saved)
+	}
+}
+
+func TestUpdateSingleDataToDbWritesEnabledProfileDetails(t *testing.T) {
+	appContext := newTestContext(t)
+	insertBloggers(t, appContext, 1)
+	config := *appContext.Config
+	config.ParseFollowingCount = true
+	config.ParseFullName = true
+	config.ParseVerified = true
+
+	users, err := appContext.Bloggers.FindBloger(1, appContext.MachineCode, time.Minute)
+	if err != nil || len(users) != 1 {
+		t.Fatalf("claim got %d users, err %v", len(users), err)
+	}
+	user := users[0]
+	user.FansCount = 100
+	user.FollowingCount = 12
+	user.PostCount = 300
+	user.Details = &ProfileDetails{FullName: "Blogger", Biography: "bio", IsVerified: true}
+	appContext.Bloggers.UpdateSingleDataToDb(user, "account", appContext.MachineCode, &config)
+
+	var saved User
+	appContext.Db.Table(appContext.Config.Table).First(&saved)
+	if saved.FollowingCount != 12 || saved.FullName != "Blogger" || !saved.IsVerified {
+		t.Fatalf("enabled fields were not written, got %+v", saved)
+	}
+	if saved.PostCount != -1 || saved.Biography != "" {
+		t.Fatalf("disabled fields must not be written, got %+v", saved)
+	}
+}
+
+func TestUpdateSingleDataToDbKeepsFieldsMissingFromPartialDetails(t *testing.T) {
+	appContext := newTestContext(t)
+	insertBloggers(t, appContext, 1)
+	config := *appContext.Config
+	config.ParseFullName = true
+	config.ParseBiography = true
+	config.ParseVerified = true
+
+	for _, details := range []*ProfileDetails{
+		{FullName: "Blogger", Biography: "bio", IsVerified: true},
+		// 只从 og:title 拿到昵称, 简介和认证标记保持上一次的值
+		{FullName: "Blogger 2", Partial: true},
+	} {
+		users, err := appContext.Bloggers.FindBloger(1, appContext.MachineCode, time.Minute)
+		if err != nil || len(users) != 1 {
+			t.Fatalf("claim got %d users, err %v", len(users), err)
+		}
+		users[0].FansCount = 100
+		users[0].Details = details
+		appContext.Bloggers.UpdateSingleDataToDb(users[0], "account", appContext.MachineCode, &config)
+		appContext.Db.Table(appContext.Config.Table).Where("id = ?", users[0].Id).Update("scrape_status", ScrapeStatusPending)
+	}
+
+	var saved User
+	appContext.Db.Table(appContext.Config.Table).First(&saved)
+	if saved.FullName != "Blogger 2" || saved.Biography != "bio" || !saved.IsVerified {
+		t.Fatalf("partial details should only overwrite found fields, got %+v", saved)
+	}
+}
+
+func TestFindAccountRevivesCooledDownAccount(t *testing.T) {
+	appContext := newTestContext(t)
+	accounts := appContext.Db.Table(appContext.Config.AccountTable)
+	if err := accounts.Create(&Account{Username: "cooling", Password: "psw"}).Error; err != nil {
+		t.Fatalf("insert account: %v", err)
+	}
+
+	account := appContext.Accounts.FindAccount(appContext.MachineCode)
+	if account == nil {
+		t.Fatalf("idle account should be found")
+	}
+	change := AccountStatusChange{Status: AccountStatusUnusable, Reason: AccountReasonHelpConfirm, MachineCode: appContext.MachineCode, BloggerUrl: "https://www.instagram.com/blogger"}
+	appContext.Accounts.MarkAccountStatus(account, change)
+	if appContext.Accounts.FindAccount(appContext.MachineCode) != nil {
+		t.Fatalf("unusable account must not be found during cooldown")
+	}
+
+	expired := time.Now().Add(-appContext.Config.AccountCooldown() - time.Minute)
+	appContext.Db.Table(appContext.Config.AccountTable).Where("user = ?", "cooling").Update("status_changed_at", expired)
+	account = appContext.Accounts.FindAccount(appContext.MachineCode)
+	if account == nil || account.StatusReason != AccountReasonCooldownExpired {
+		t.Fatalf("account should be revived after cooldown, got %+v", account)
+	}
+
+	history, err := appContext.Accounts.FindAccountHistory("cooling", 0)
+	if err != nil {
+		t.Fatalf("find history: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("got %d audit rows, want 2", len(history))
+	}
+	if history[0].OldStatus != AccountStatusUnusable || history[0].NewStatus != AccountStatusIdle || history[0].Reason != AccountReasonCooldownExpired {
+		t.Fatalf("unexpected revive audit %+v", history[0])
+	}
+	if history[1].OldStatus != AccountStatusIdle || history[1].BloggerUrl != change.BloggerUrl || history[1].MachineCode != appContext.MachineCode {
+		t.Fatalf("unexpected mark audit %+v", history[1])
+	}
+}
+
+func TestChangeAccountsStatusRechecksPredicate(t *testing.T) {
+	appContext := newTestContext(t)
+	db, table := appContext.AccountDb, appContext.Config.AccountTable
+	db.Table(table).Create(&Account{Username: "busy", Status: AccountStatusInUse, MachineCode: appContext.MachineCode})
+
+	// 选出账号之后、修改之前账号被标记为 invalid, 释放时不能把它改回 idle, 也不写审计
+	var calls int
+	where := func(tx *gorm.DB) *gorm.DB {
+		calls++
+		if calls == 2 {
+			tx.Session(&gorm.Session{NewDB: true}).Table(table).Where("user = ?", "busy").Update("status", AccountStatusInvalid)
+		}
+		return tx.Where("status = ?", AccountStatusInUse)
+	}
+	count, err := changeAccountsStatus(db, table, where, AccountStatusChange{Status: AccountStatusIdle, Reason: AccountReasonReleased})
+	if err != nil || count != 0 {
+		t.Fatalf("got %d, %v, want no change", count, err)
+	}
+	var account Account
+	db.Table(table).Where("user = ?", "busy").First(&account)
+	if account.Status != AccountStatusInvalid {
+		t.Fatalf("invalid mark was overwritten: %v", account)
+	}
+	if history, _ := appContext.Accounts.FindAccountHistory("busy", 0); len(history) != 0 {
+		t.Fatalf("no audit expected, got %+v", history)
+	}
+
+	// 不带机器码的状态变化保留原来的机器码
+	appContext.Accounts.MarkAccountStatus(&account, AccountStatusChange{Status: AccountStatusIdle, Reason: AccountReasonReleased})
+	db.Table(table).Where("user = ?", "busy").First(&account)
+	if account.MachineCode != appContext.MachineCode {
+		t.Fatalf("machine code should be kept, got %q", account.MachineCode)
+	}
+}
+
+func TestUpdateSingleDataToDbUpsertsStoryLinks(t *testing.T) {
+	appContext := newTestContext(t)
+	insertBloggers(t, appContext, 1)
+
+	for _, storyId := range []string{"1_1", "2_1"} {
+		users, err := appContext.Bloggers.FindBloger(1, appContext.MachineCode, time.Minute)
+		if err != nil || len(users) != 1 {
+			t.Fatalf("claim got %d users, err %v", len(users), err)
+		}
+		user := users[0]
+		user.FansCount = 100
+		user.Stories = []StoryItem{{Id: storyId, Links: []StoryLinkSticker{
+			{Url: "https://l.instagram.com/?u=https%3A%2F%2Fwww.Shop.com%2Fa%2Cb", Link: "https://www.Shop.com/a,b"},
+			{Url: "https://example.org", Link: "https://example.org"},
+		}}}
+		user.StoryLink = "https://www.Shop.com/a,b,https://example.org"
+		appContext.Bloggers.UpdateSingleDataToDb(user, "account", appContext.MachineCode, appContext.Config)
+		appContext.Db.Table(appContext.Config.Table).Where("id = ?", user.Id).Update("scrape_status", ScrapeStatusPending)
+	}
+
+	links, err := appContext.Bloggers.FindStoryLinks(1)
+	if err != nil || len(links) != 2 {
+		t.Fatalf("got %d story links, err %v", len(links), err)
+	}
+	for _, link := range links {
+		if link.StoryItemId != "2_1" || link.LastSeen.Before(link.FirstSeen) {
+			t.Fatalf("story link should be updated by the latest scrape, got %+v", link)
+		}
+	}
+
+	links, err = appContext.Bloggers.FindStoryLinksByDomain("shop.com")
+	if err != nil || len(links) != 1 {
+		t.Fatalf("got %d links for shop.com, err %v", len(links), err)
+	}
+	if links[0].ResolvedLink != "https://www.Shop.com/a,b" || links[0].BloggerUrl != "https://www.instagram.com/blogger_0" {
+		t.Fatalf("unexpected link %+v", links[0])
+	}
+}
+
+func TestUpsertStoryLinksKeepsFinalLink(t *testing.T) {
+	appContext := newTestContext(t)
+	insertBloggers(t, appContext, 1)
+
+	stories := [][]StoryLinkSticker{
+		{{Link: "https://bit.ly/abc", FinalLink: "https://www.shop.com/sale", RedirectChain: []string{"https://bit.ly/abc", "https://www.shop.com/sale"}}},
+		// 后一次没有解析跳转, 不能清掉之前的结果
+		{{Link: "https://bit.ly/abc"}},
+	}
+	for _, links := range stories {
+		users, err := appContext.Bloggers.FindBloger(1, appContext.MachineCode, time.Minute)
+		if err != nil || len(users) != 1 {
+			t.Fatalf("claim got %d users, err %v", len(users), err)
+		}
+		user := users[0]
+		user.Stories = []StoryItem{{Id: "1_1", Links: links}}
+		appContext.Bloggers.UpdateSingleDataToDb(user, "account", appContext.MachineCode, appContext.Config)
+		appContext.Db.Table(appContext.Config.Table).Where("id = ?", user.Id).Update("scrape_status", ScrapeStatusPending)
+	}
+
+	links, err := appContext.Bloggers.FindStoryLinksByDomain("shop.com")
+	if err != nil || len(links) != 1 {
+		t.Fatalf("got %d links for shop.com, err %v", len(links), err)
+	}
+	want := StringList{"https://bit.ly/abc", "https://www.shop.com/sale"}
+	if links[0].Domain != "bit.ly" || links[0].FinalLink != "https://www.shop.com/sale" || !reflect.DeepEqual(links[0].RedirectChain, want) {
+		t.Fatalf("unexpected link %+v", links[0])
+	}
+}
+
+func TestCreateStoryLinkMigratesJoinedLinks(t *testing.T) {
+	appContext := newTestContext(t)
+	insertBloggers(t, appContext, 2)
+	for lastBloggerMigration(t, appContext) != "create_story_link" {
+		if err := MigrateDown(appContext, MigrationScopeBlogger); err != nil {
+			t.Fatalf("migrate down: %v", err)
+		}
+	}
+	if err := MigrateDown(appContext, MigrationScopeBlogger); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	appContext.Db.Table(appContext.Config.Table).Where("id = ?", 1).Update("story_link", "https://a.com/?ids=1,2,https://www.b.com")
+
+	if err := MigrateUp(appContext); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	links, err := appContext.Bloggers.FindStoryLinks(1)
+	if err != nil || len(links) != 2 {
+		t.Fatalf("got %d story links, err %v", len(links), err)
+	}
+	got := map[string]string{links[0].ResolvedLink: links[0].Domain, links[1].ResolvedLink: links[1].Domain}
+	want := map[string]string{"https://a.com/?ids=1,2": "a.com", "https://www.b.com": "b.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// lastBloggerMigration 返回博主库最近一次执行的变更名
+func lastBloggerMigration(t *testing.T, appContext *AppContext) string {
+	t.Helper()
+	applied, err := MigrationStatus(appContext)
+	if err != nil {
+		t.Fatalf("migration status: %v", err)
+	}
+	last := ""
+	for _, item := range applied {
+		if item.Scope == MigrationScopeBlogger {
+			last = item.Name
+		}
+	}
+	return last
+}
@@ -0,0 +1,211 @@
+package instagram_fans
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/playwright-community/playwright-go"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Fingerprint 账号使用的浏览器特征, 第一次使用账号时生成并保存在账号表里,
+// 之后每次登录都用同一份, 让账号看起来一直在同一台设备上。换了 browserEngine 后重新生成
+type Fingerprint struct {
+	// Engine 生成指纹时使用的浏览器引擎, 早期保存的指纹没有这一项
+	Engine            string  `json:"engine,omitempty"`
+	UserAgent         string  `json:"userAgent"`
+	ViewportWidth     int     `json:"viewportWidth"`
+	ViewportHeight    int     `json:"viewportHeight"`
+	DeviceScaleFactor float64 `json:"deviceScaleFactor"`
+	Timezone          string  `json:"timezone"`
+	Locale            string  `json:"locale"`
+	AcceptLanguage    string  `json:"acceptLanguage"`
+	// Device 模拟的 playwright 设备名, 如 "iPhone 13", 为空时是桌面浏览器
+	Device   string `json:"device,omitempty"`
+	IsMobile bool   `json:"isMobile,omitempty"`
+	HasTouch bool   `json:"hasTouch,omitempty"`
+}
+
+func (fingerprint Fingerprint) String() string {
+	device := fingerprint.Device
+	if device == "" {
+		device = "desktop"
+	}
+	return fmt.Sprintf("{device:%s viewport:%dx%d@%v locale:%s timezone:%s}", device, fingerprint.ViewportWidth,
+		fingerprint.ViewportHeight, fingerprint.DeviceScaleFactor, fingerprint.Locale, fingerprint.Timezone)
+}
+
+func (fingerprint Fingerprint) Value() (driver.Value, error) {
+	data, err := json.Marshal(fingerprint)
+	return string(data), err
+}
+
+func (fingerprint *Fingerprint) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return errors.Errorf("can not scan %T into Fingerprint", value)
+	}
+	return json.Unmarshal(data, fingerprint)
+}
+
+// matchesEngine 指纹是否是为 engine 生成的。早期的指纹没有记录引擎, 桌面指纹按 UA 判断, 设备指纹的 UA 来自设备, 视为一致
+func (fingerprint *Fingerprint) matchesEngine(engine string) bool {
+	if fingerprint.Engine != "" {
+		return fingerprint.Engine == engine
+	}
+	if fingerprint.Device != "" {
+		return true
+	}
+	switch {
+	case strings.Contains(fingerprint.UserAgent, "Firefox/"):
+		return engine == BrowserFirefox
+	case strings.Contains(fingerprint.UserAgent, "Chrome/"):
+		return engine == BrowserChromium
+	default:
+		return engine == BrowserWebkit
+	}
+}
+
+// applyTo 把指纹设置到浏览器上下文的参数里
+func (fingerprint *Fingerprint) applyTo(options *playwright.BrowserNewContextOptions) {
+	options.UserAgent = playwright.String(fingerprint.UserAgent)
+	options.Viewport = &playwright.Size{Width: fingerprint.ViewportWidth, Height: fingerprint.ViewportHeight}
+	options.DeviceScaleFactor = playwright.Float(fingerprint.DeviceScaleFactor)
+	options.TimezoneId = playwright.String(fingerprint.Timezone)
+	options.Locale = playwright.String(fingerprint.Locale)
+	options.ExtraHttpHeaders = map[string]string{"Accept-Language": fingerprint.AcceptLanguage}
+	if fingerprint.IsMobile {
+		options.IsMobile = playwright.Bool(true)
+	}
+	if fingerprint.HasTouch {
+		options.HasTouch = playwright.Bool(true)
+	}
+}
+
+type fingerprintRegion struct {
+	locale         string
+	timezone       string
+	acceptLanguage string
+}
+
+// 只使用英文页面的地区, 粉丝数按英文缩写解析最稳定
+var fingerprintRegions = []fingerprintRegion{
+	{"en-US", "America/New_York", "en-US,en;q=0.9"},
+	{"en-US", "America/Chicago", "en-US,en;q=0.9"},
+	{"en-US", "America/Los_Angeles", "en-US,en;q=0.9"},
+	{"en-GB", "Europe/London", "en-GB,en;q=0.9"},
+	{"en-CA", "America/Toronto", "en-CA,en;q=0.9"},
+	{"en-AU", "Australia/Sydney", "en-AU,en;q=0.9"},
+}
+
+type fingerprintScreen struct {
+	width  int
+	height int
+	scale  float64
+}
+
+var desktopScreens = []fingerprintScreen{
+	{1920, 1080, 1},
+	{1536, 864, 1.25},
+	{1440, 900, 2},
+	{1366, 768, 1},
+	{1280, 800, 2},
+	{2560, 1440, 1},
+}
+
+// defaultBrowserVersions 读不到浏览器版本时 UA 里使用的版本
+var defaultBrowserVersions = map[string]string{
+	BrowserChromium: "124.0.0.0",
+	BrowserFirefox:  "125.0",
+	BrowserWebkit:   "17.4",
+}
+
+// desktopUserAgent 按实际的浏览器引擎、版本和本机系统拼出桌面 UA, 与浏览器的其他特征保持一致才不容易被识别。
+// Chrome 的 UA 只保留主版本号
+func desktopUserAgent(engine string, version string, goos string) string {
+	if version == "" {
+		version = defaultBrowserVersions[engine]
+	}
+	major, _, _ := strings.Cut(version, ".")
+	switch engine {
+	case BrowserFirefox:
+		platform := map[string]string{"windows": "Windows NT 10.0; Win64; x64", "darwin": "Macintosh; Intel Mac OS X 10.15"}[goos]
+		if platform == "" {
+			platform = "X11; Linux x86_64"
+		}
+		return fmt.Sprintf("Mozilla/5.0 (%s; rv:%s.0) Gecko/20100101 Firefox/%s.0", platform, major, major)
+	case BrowserWebkit:
+		return fmt.Sprintf("Mozilla/5.0 (%s) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/%s Safari/605.1.15", hostPlatform(goos), version)
+	default:
+		return fmt.Sprintf("Mozilla/5.0 (%s) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s.0.0.0 Safari/537.36", hostPlatform(goos), major)
+	}
+}
+
+// hostPlatform Chromium 和 WebKit UA 里的系统部分
+func hostPlatform(goos string) string {
+	switch goos {
+	case "windows":
+		return "Windows NT 10.0; Win64; x64"
+	case "darwin":
+		return "Macintosh; Intel Mac OS X 10_15_7"
+	default:
+		return "X11; Linux x86_64"
+	}
+}
+
+// GenerateFingerprint 随机生成一份指纹。devices 不为空时从中选一个设备模拟(UA、视口、缩放、触屏),
+// 否则按 engine 和浏览器版本 browserVersion 生成本机系统上的桌面浏览器指纹
+func GenerateFingerprint(random *rand.Rand, engine string, browserVersion string, devices map[string]*playwright.DeviceDescriptor) *Fingerprint {
+	region := fingerprintRegions[random.Intn(len(fingerprintRegions))]
+	fingerprint := &Fingerprint{Engine: engine, Timezone: region.timezone, Locale: region.locale, AcceptLanguage: region.acceptLanguage}
+
+	if len(devices) > 0 {
+		// 按名字排序后再选, 同一个随机数得到同一个设备
+		names := make([]string, 0, len(devices))
+		for name := range devices {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		name := names[random.Intn(len(names))]
+		device := devices[name]
+		fingerprint.Device = name
+		fingerprint.UserAgent = device.UserAgent
+		if device.Viewport != nil {
+			fingerprint.ViewportWidth = device.Viewport.Width
+			fingerprint.ViewportHeight = device.Viewport.Height
+		}
+		fingerprint.DeviceScaleFactor = device.DeviceScaleFactor
+		fingerprint.IsMobile = device.IsMobile
+		fingerprint.HasTouch = device.HasTouch
+		return fingerprint
+	}
+
+	screen := desktopScreens[random.Intn(len(desktopScreens))]
+	fingerprint.UserAgent = desktopUserAgent(engine, browserVersion, runtime.GOOS)
+	fingerprint.ViewportWidth = screen.width
+	fingerprint.ViewportHeight = screen.height
+	fingerprint.DeviceScaleFactor = screen.scale
+	return fingerprint
+}
+
+// fingerprintDevices 从 playwright 内置设备里取出配置的设备, 不存在的设备名返回错误
+func fingerprintDevices(all map[string]*playwright.DeviceDescriptor, names []string) (map[string]*playwright.DeviceDescriptor, error) {
+	devices := make(map[string]*playwright.DeviceDescriptor, len(names))
+	for _, name := range names {
+		device, ok := all[name]
+		if !ok {
+			return nil, errors.Errorf("unknown playwright device %q", name)
+		}
+		devices[name] = device
+	}
+	return devices, nil
+}
@@ -0,0 +1,114 @@
+package instagram_fans
+
+import (
+	"github.com/playwright-community/playwright-go"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateFingerprint(t *testing.T) {
+	desktop := GenerateFingerprint(rand.New(rand.NewSource(1)), BrowserFirefox, "126.0.1", nil)
+	if !strings.Contains(desktop.UserAgent, "Firefox/126.0") || desktop.Engine != BrowserFirefox || desktop.Device != "" || desktop.IsMobile {
+		t.Fatalf("firefox desktop fingerprint got %+v", desktop)
+	}
+	if desktop.ViewportWidth == 0 || desktop.DeviceScaleFactor == 0 || desktop.Timezone == "" || !strings.HasPrefix(desktop.AcceptLanguage, desktop.Locale) {
+		t.Fatalf("incomplete fingerprint %+v", desktop)
+	}
+	if again := GenerateFingerprint(rand.New(rand.NewSource(1)), BrowserFirefox, "126.0.1", nil); !reflect.DeepEqual(again, desktop) {
+		t.Fatalf("same seed got %+v, want %+v", again, desktop)
+	}
+
+	devices := map[string]*playwright.DeviceDescriptor{
+		"iPhone 13": {UserAgent: "iPhone UA", Viewport: &playwright.Size{Width: 390, Height: 664}, DeviceScaleFactor: 3, IsMobile: true, HasTouch: true},
+	}
+	mobile := GenerateFingerprint(rand.New(rand.NewSource(1)), BrowserWebkit, "17.4", devices)
+	if mobile.Device != "iPhone 13" || mobile.UserAgent != "iPhone UA" || mobile.ViewportWidth != 390 || mobile.DeviceScaleFactor != 3 || !mobile.IsMobile || !mobile.HasTouch {
+		t.Fatalf("device fingerprint got %+v", mobile)
+	}
+
+	var options playwright.BrowserNewContextOptions
+	mobile.applyTo(&options)
+	if *options.UserAgent != "iPhone UA" || options.Viewport.Width != 390 || *options.TimezoneId != mobile.Timezone ||
+		options.ExtraHttpHeaders["Accept-Language"] != mobile.AcceptLanguage || options.IsMobile == nil || !*options.IsMobile {
+		t.Fatalf("context options got %+v", options)
+	}
+
+	if _, err := fingerprintDevices(devices, []string{"Pixel 7"}); err == nil {
+		t.Fatalf("unknown device should fail")
+	}
+}
+
+func TestDesktopUserAgentFollowsBrowserAndHost(t *testing.T) {
+	cases := []struct {
+		engine, version, goos, want string
+	}{
+		{BrowserChromium, "124.0.6367.29", "linux", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"},
+		{BrowserChromium, "125.0.6422.26", "windows", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36"},
+		{BrowserFirefox, "126.0.1", "darwin", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:126.0) Gecko/20100101 Firefox/126.0"},
+		{BrowserWebkit, "17.4", "linux", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15"},
+		{BrowserFirefox, "", "linux", "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0"},
+	}
+	for _, c := range cases {
+		if got := desktopUserAgent(c.engine, c.version, c.goos); got != c.want {
+			t.Errorf("%s %s on %s got %q, want %q", c.engine, c.version, c.goos, got, c.want)
+		}
+	}
+}
+
+func TestAccountFingerprintRegeneratedForNewEngine(t *testing.T) {
+	appContext := newTestContext(t)
+	appContext.AccountDb.Table(appContext.Config.AccountTable).Create(&Account{Username: "lun", Password: "psw"})
+	account := appContext.Accounts.FindAccount(appContext.MachineCode)
+
+	// 没有记录引擎的旧指纹按 UA 判断
+	legacy := &Fingerprint{UserAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:126.0) Gecko/20100101 Firefox/126.0", ViewportWidth: 1280}
+	account.Fingerprint = legacy
+	appContext.Config.BrowserEngine = BrowserFirefox
+	if fingerprint, _ := accountFingerprint(appContext, account); fingerprint != legacy {
+		t.Fatalf("legacy firefox fingerprint should be kept for firefox")
+	}
+
+	appContext.Config.BrowserEngine = BrowserChromium
+	fingerprint, err := accountFingerprint(appContext, account)
+	if err != nil || fingerprint == legacy || fingerprint.Engine != BrowserChromium || !strings.Contains(fingerprint.UserAgent, "Chrome/") {
+		t.Fatalf("fingerprint for chromium got %+v, %v", fingerprint, err)
+	}
+	if reloaded := appContext.Accounts.FindAccount(appContext.MachineCode); reloaded.Fingerprint == nil || reloaded.Fingerprint.Engine != BrowserChromium {
+		t.Fatalf("regenerated fingerprint should be saved, got %+v", reloaded.Fingerprint)
+	}
+}
+
+func TestAccountFingerprintIsPersisted(t *testing.T) {
+	appContext := newTestContext(t)
+	accounts := appContext.AccountDb.Table(appContext.Config.AccountTable)
+	if err := accounts.Create(&Account{Username: "lun", Password: "psw"}).Error; err != nil {
+		t.Fatalf("insert account: %v", err)
+	}
+
+	account := appContext.Accounts.FindAccount(appContext.MachineCode)
+	if account == nil || account.Fingerprint != nil {
+		t.Fatalf("new account got %+v, want no fingerprint", account)
+	}
+	fingerprint, err := accountFingerprint(appContext, account)
+	if err != nil {
+		t.Fatalf("generate fingerprint: %v", err)
+	}
+
+	// 再次取出账号时使用保存的指纹, 不会重新生成
+	reloaded := appContext.Accounts.FindAccount(appContext.MachineCode)
+	if reloaded.Fingerprint == nil || !reflect.DeepEqual(*reloaded.Fingerprint, *fingerprint) {
+		t.Fatalf("reloaded fingerprint got %+v, want %+v", reloaded.Fingerprint, fingerprint)
+	}
+	if again, _ := accountFingerprint(appContext, reloaded); again != reloaded.Fingerprint {
+		t.Fatalf("saved fingerprint should be reused")
+	}
+
+	if err := appContext.Accounts.SetAccountFingerprint("lun", nil); err != nil {
+		t.Fatalf("reset fingerprint: %v", err)
+	}
+	if reset := appContext.Accounts.FindAccount(appContext.MachineCode); reset.Fingerprint != nil {
+		t.Fatalf("fingerprint should be cleared, got %+v", reset.Fingerprint)
+	}
+}
@@ -2,45 +2,178 @@ package instagram_fans
 
 import (
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
-func ParseFollowerCount(s string) (int, error) {
-	// 去掉字符串中的空格
-	s = strings.TrimSpace(s)
-	s = strings.ReplaceAll(s, ",", "")
+// countUnits 数量缩写对应的倍数, 统一用小写、去掉结尾的点
+var countUnits = map[string]float64{
+	"":     1,
+	"k":    1e3,
+	"mil":  1e3, // pt / es: 1,2 mil
+	"тыс":  1e3,
+	"千":    1e3,
+	"万":    1e4,
+	"萬":    1e4,
+	"만":    1e4,
+	"m":    1e6,
+	"mi":   1e6, // pt-BR: 1,5 mi
+	"mill": 1e6, // es: 1,5 mill.
+	"mio":  1e6, // de: 1,5 Mio.
+	"mln":  1e6,
+	"млн":  1e6,
+	"亿":    1e8,
+	"億":    1e8,
+	"억":    1e8,
+	"b":    1e9,
+	"bi":   1e9,
+	"bn":   1e9,
+	"mrd":  1e9,
+	"млрд": 1e9,
+}
 
-	// 检查字符串的最后一个字符以确定单位
-	length := len(s)
-	if length == 0 {
-		return 0, fmt.Errorf("invalid format")
-	}
+// commaDecimalLanguages 用逗号作小数点的语言
+var commaDecimalLanguages = map[string]bool{
+	"pt": true, "es": true, "ru": true, "uk": true, "de": true, "fr": true, "it": true, "nl": true,
+	"pl": true, "tr": true, "id": true, "vi": true, "cs": true, "ro": true, "hu": true, "sv": true,
+	"da": true, "nb": true, "fi": true,
+}
 
-	unit := s[length-1]
-	numberStr := s[:length-1]
-	var multiplier int
+var (
+	countNumberPattern = regexp.MustCompile(`^(\d[\d.,' ]*)(.*)$`)
+	// countTokenPattern 匹配文字开头的数量, 包括可选的缩写单位, 如 "12,5 тыс." "1.2万" "12.3K"
+	countTokenPattern = regexp.MustCompile(`(?i)^\s*(` + countToken + `)`)
+	htmlLangPattern   = regexp.MustCompile(`(?i)<html[^>]*\slang="([^"]+)"`)
+)
 
-	switch unit {
-	case 'K', 'k':
-		multiplier = 1000
-	case 'M', 'm':
-		multiplier = 1000000
-	case 'B', 'b':
-		multiplier = 1000000000
-	default:
-		// 如果没有单位，尝试直接转换为整数
-		numberStr = s
-		multiplier = 1
+// countToken 数量加可选的缩写单位, 拉丁字母单位后面必须是单词边界, 避免把 "Beiträge" 的 B 当成单位
+const countToken = `\d[\d.,'\x{00a0}\x{202f}\x{2009} ]*(?:\s?(?:(?:mill|mil|mio|mln|mrd|mi|bn|bi|k|m|b)\b|тыс|млрд|млн|[千万萬亿億만억])\.?)?`
+
+// CountFormatError 无法识别的数量格式
+type CountFormatError struct {
+	Text   string
+	Locale string
+	Reason string
+}
+
+func (e *CountFormatError) Error() string {
+	return fmt.Sprintf("can not parse count %q (locale %q): %s", e.Text, e.Locale, e.Reason)
+}
+
+// normalizeCountText 把不换行空格等替换成普通空格
+func normalizeCountText(s string) string {
+	s = strings.NewReplacer("\u00a0", " ", "\u202f", " ", "\u2009", " ").Replace(s)
+	return strings.TrimSpace(s)
+}
+
+// ParseFollowerCount 解析页面上的粉丝数文字, 如 "1,234" "12.3K" "1,2 mil" "12,5 тыс." "1.2万" "1.5 Mio."。
+// locale 是页面语言(如 pt-BR), 只用来判断没有单位、只有一个分隔符时分隔符是小数点还是千分位, 可以为空
+func ParseFollowerCount(s string, locale string) (int, error) {
+	text := normalizeCountText(s)
+	match := countNumberPattern.FindStringSubmatch(text)
+	if match == nil {
+		return 0, &CountFormatError{Text: s, Locale: locale, Reason: "no number"}
+	}
+
+	unit := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(match[2])), ".")
+	multiplier, ok := countUnits[unit]
+	if !ok {
+		return 0, &CountFormatError{Text: s, Locale: locale, Reason: "unknown unit " + unit}
 	}
 
-	// 将数字部分转换为浮点数
+	numberStr, err := normalizeCountNumber(strings.TrimSpace(match[1]), multiplier > 1, locale)
+	if err != nil {
+		return 0, &CountFormatError{Text: s, Locale: locale, Reason: err.Error()}
+	}
 	number, err := strconv.ParseFloat(numberStr, 64)
 	if err != nil {
-		return 0, fmt.Errorf("invalid number format: %v", err)
+		return 0, &CountFormatError{Text: s, Locale: locale, Reason: "invalid number " + numberStr}
 	}
+	return int(math.Round(number * multiplier)), nil
+}
+
+// normalizeCountNumber 去掉千分位, 把小数点统一成 "."
+func normalizeCountNumber(number string, hasUnit bool, locale string) (string, error) {
+	number = strings.NewReplacer(" ", "", "'", "").Replace(number)
+	dots, commas := strings.Count(number, "."), strings.Count(number, ",")
 
-	// 计算实际的粉丝数
-	followers := int(number * float64(multiplier))
-	return followers, nil
+	var decimal string
+	switch {
+	case dots > 0 && commas > 0:
+		// 两种分隔符都有时, 最后出现的是小数点
+		if strings.LastIndex(number, ".") > strings.LastIndex(number, ",") {
+			decimal = "."
+		} else {
+			decimal = ","
+		}
+	case dots+commas == 0:
+		return number, nil
+	case dots > 1 || commas > 1:
+		// 同一个分隔符出现多次只能是千分位
+	default:
+		separator := "."
+		if commas == 1 {
+			separator = ","
+		}
+		fraction := number[strings.Index(number, separator)+1:]
+		switch {
+		case hasUnit:
+			// 带单位的缩写总是小数, 如 1,2 mil / 1.2万
+			decimal = separator
+		case len(fraction) == 3:
+			// 1.234 / 1,234 没有单位时是千分位
+		case separator == localeDecimal(locale):
+			decimal = separator
+		default:
+			return "", fmt.Errorf("ambiguous separator %q", separator)
+		}
+	}
+
+	grouping := ","
+	if decimal == "," {
+		grouping = "."
+	}
+	if decimal == "" {
+		return strings.NewReplacer(".", "", ",", "").Replace(number), nil
+	}
+	number = strings.ReplaceAll(number, grouping, "")
+	if strings.Count(number, decimal) > 1 {
+		return "", fmt.Errorf("more than one decimal separator")
+	}
+	return strings.Replace(number, decimal, ".", 1), nil
+}
+
+// localeDecimal locale 使用的小数点, 未知语言按英文处理
+func localeDecimal(locale string) string {
+	language := strings.ToLower(strings.SplitN(strings.ReplaceAll(locale, "_", "-"), "-", 2)[0])
+	if commaDecimalLanguages[language] {
+		return ","
+	}
+	return "."
+}
+
+// ExtractCountText 取出文字开头的数量部分, 如 "1,2 mil seguidores" 返回 "1,2 mil"
+func ExtractCountText(text string) string {
+	match := countTokenPattern.FindStringSubmatch(normalizeCountText(text))
+	if match == nil {
+		return ""
+	}
+	return strings.TrimSpace(match[1])
+}
+
+// IsExactCount 数量文字没有 K/M/B/万 这类缩写时是精确值
+func IsExactCount(s string) bool {
+	s = normalizeCountText(s)
+	return s != "" && s[len(s)-1] >= '0' && s[len(s)-1] <= '9'
+}
+
+// PageLocale 取出页面 <html lang="..."> 里的语言, 作为解析数量时的 locale 提示
+func PageLocale(content string) string {
+	match := htmlLangPattern.FindStringSubmatch(content)
+	if match == nil {
+		return ""
+	}
+	return match[1]
 }
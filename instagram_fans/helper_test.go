@@ -0,0 +1,65 @@
+package instagram_fans
+
+import (
+	"github.com/pkg/errors"
+	"testing"
+)
+
+func TestParseFollowerCount(t *testing.T) {
+	cases := []struct {
+		text   string
+		locale string
+		want   int
+	}{
+		{text: "1,234", want: 1234},
+		{text: "12.3K", want: 12300},
+		{text: "1.15M", want: 1150000},
+		{text: "2B", want: 2000000000},
+		{text: "1,2 mil", locale: "pt-BR", want: 1200},
+		{text: "1,5 mi", locale: "pt-BR", want: 1500000},
+		{text: "1.234", locale: "pt-BR", want: 1234},
+		{text: "1.234.567", locale: "es", want: 1234567},
+		{text: "12,5 тыс.", locale: "ru", want: 12500},
+		{text: "3,1 млн", locale: "ru", want: 3100000},
+		{text: "1.2万", locale: "zh-CN", want: 12000},
+		{text: "3亿", locale: "zh-CN", want: 300000000},
+		{text: "1.5 Mio.", locale: "de", want: 1500000},
+		{text: "2,3 Mrd.", locale: "de", want: 2300000000},
+		{text: "12 345", locale: "fr", want: 12345},
+		{text: "1 234,5 k", locale: "fr", want: 1234500},
+		{text: "1,234.5K", want: 1234500},
+	}
+	for _, item := range cases {
+		got, err := ParseFollowerCount(item.text, item.locale)
+		if err != nil || got != item.want {
+			t.Errorf("ParseFollowerCount(%q, %q) = %d, %v, want %d", item.text, item.locale, got, err, item.want)
+		}
+	}
+}
+
+func TestParseFollowerCountRejectsUnknownFormat(t *testing.T) {
+	for _, text := range []string{"", "followers", "12 xyz", "12,5", "1.2.3,4,5"} {
+		_, err := ParseFollowerCount(text, "en-US")
+		var formatErr *CountFormatError
+		if !errors.As(err, &formatErr) || formatErr.Text != text {
+			t.Errorf("ParseFollowerCount(%q) got %v, want CountFormatError", text, err)
+		}
+	}
+}
+
+func TestExtractCountText(t *testing.T) {
+	cases := map[string]string{
+		"12.3K followers":       "12.3K",
+		"1,2 mil seguidores":    "1,2 mil",
+		"12,5 тыс. подписчиков": "12,5 тыс.",
+		"1.2万粉丝":                "1.2万",
+		"1 234 Beiträge":        "1 234",
+		"500 followers":         "500",
+		"1.5 Mio. Follower":     "1.5 Mio.",
+	}
+	for text, want := range cases {
+		if got := ExtractCountText(text); got != want {
+			t.Errorf("ExtractCountText(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package instagram_fans
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultInstagramBaseUrl = "https://www.instagram.com"
+	// instagramWebAppId 网页版请求接口时带的 X-IG-App-ID
+	instagramWebAppId  = "936619743392459"
+	currentUserPath    = "/api/v1/accounts/current_user/?edit=true"
+	webProfileInfoPath = "/api/v1/users/web_profile_info/"
+	reelsMediaPath     = "/api/v1/feed/reels_media/"
+	maxApiResponseSize = 8 << 20
+)
+
+// HttpScraper 用账号的 Cookie 直接请求网页版使用的 JSON 接口, 不需要启动浏览器
+type HttpScraper struct {
+	Account *Account
+	BaseUrl string
+	client  *http.Client
+	config  *Config
+	proxy   proxyBinding
+	// fingerprint 与浏览器后端共用的账号指纹, 请求带上其中的 UA 和语言
+	fingerprint *Fingerprint
+}
+
+// NewHttpScraper 载入 account 的 Cookie 并确认登录状态仍然有效
+func NewHttpScraper(appContext *AppContext, account *Account) (Scraper, error) {
+	baseUrl := appContext.Config.HttpBaseUrl
+	if baseUrl == "" {
+		baseUrl = defaultInstagramBaseUrl
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	binding, err := bindProxy(appContext, account)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := httpFingerprint(appContext, account)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if binding.proxy != nil {
+		proxyUrl, err := binding.proxy.ProxyUrl()
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+	scraper := &HttpScraper{
+		Account: account,
+		BaseUrl: strings.TrimRight(baseUrl, "/"),
+		client: &http.Client{
+			Jar:       jar,
+			Timeout:   appContext.Config.HttpTimeout(),
+			Transport: transport,
+			// 跳转到登录页或验证页说明 Cookie 已经失效, 不跟随跳转
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		config:      appContext.Config,
+		proxy:       binding,
+		fingerprint: fingerprint,
+	}
+
+	log.Infof("using account: %v", *account)
+
+	if err := scraper.Relogin(context.Background()); err != nil {
+		log.Errorf("[NewHttpScraper] Can not login to instagram!!! %v", err)
+		return scraper, err
+	}
+	return scraper, nil
+}
+
+// httpFingerprint 返回账号在浏览器后端使用的指纹, 同一个账号换了后端也还是同一台设备。
+// 保存的指纹不论是哪个引擎生成的都直接使用, 没有时生成一份并保存
+func httpFingerprint(appContext *AppContext, account *Account) (*Fingerprint, error) {
+	if account.Fingerprint != nil {
+		return account.Fingerprint, nil
+	}
+	return accountFingerprint(appContext, account)
+}
+
+// Relogin 重新载入账号的 Cookie 并检查登录状态, http 后端没法输入密码, Cookie 失效时账号视为不可用
+func (scraper *HttpScraper) Relogin(ctx context.Context) error {
+	cookies, err := scraper.Account.RevealSessionCookie()
+	if err != nil {
+		return newAccountError(errors.Wrap(ErrUserInvalid, err.Error()), AccountReasonLoginFailed)
+	}
+	if !slices.ContainsFunc(cookies, func(cookie *http.Cookie) bool { return cookie.Name == "sessionid" && cookie.Value != "" }) {
+		return newAccountError(ErrUserInvalid, AccountReasonLoginFailed)
+	}
+	baseUrl, err := url.Parse(scraper.BaseUrl)
+	if err != nil {
+		return errors.Wrapf(err, "invalid http base url %s", scraper.BaseUrl)
+	}
+	scraper.client.Jar.SetCookies(baseUrl, cookies)
+
+	err = scraper.checkSession(ctx)
+	if errors.Is(err, ErrNeedLogin) {
+		return newAccountError(ErrUserInvalid, AccountReasonSessionExpired)
+	}
+	return err
+}
+
+// checkSession 请求当前登录用户, Cookie 已经失效时返回 ErrNeedLogin
+func (scraper *HttpScraper) checkSession(ctx context.Context) error {
+	var currentUser struct {
+		User *struct {
+			Username string `json:"username"`
+		} `json:"user"`
+	}
+	err := scraper.getJson(ctx, currentUserPath, &currentUser)
+	if err == nil && currentUser.User == nil {
+		return ErrNeedLogin
+	}
+	return err
+}
+
+func (scraper *HttpScraper) FetchProfile(ctx context.Context, profileUrl string) (ProfileResult, error) {
+	result := newProfileResult()
+	username := usernameFromUrl(profileUrl)
+	if username == "" {
+		return result, errors.Wrapf(ErrProfileNotFound, "no username in %s", profileUrl)
+	}
+
+	var profile webProfileInfo
+	if err := scraper.getJson(ctx, webProfileInfoPath+"?username="+url.QueryEscape(username), &profile); err != nil {
+		// 单个主页返回 401/403 时登录状态不一定失效, 确认 Cookie 仍然有效时只算这个博主打不开
+		if errors.Is(err, ErrNeedLogin) && scraper.checkSession(ctx) == nil {
+			return result, errors.Wrapf(ErrPageUnavailable, "%s: %v", username, err)
+		}
+		return result, err
+	}
+	user := profile.Data.User
+	if user == nil {
+		return result, errors.Wrapf(ErrProfileNotFound, "no profile for %s", username)
+	}
+	result.FansCount = user.EdgeFollowedBy.Count
+	result.FansCountExact = true
+	result.FollowingCount = user.EdgeFollow.Count
+	result.PostCount = user.EdgeOwnerToTimelineMedia.Count
+	result.Details = &ProfileDetails{
+		FullName:    user.FullName,
+		Biography:   user.Biography,
+		ExternalUrl: user.ExternalUrl,
+		IsVerified:  user.IsVerified,
+		Category:    user.CategoryName,
+		IsPrivate:   user.IsPrivate,
+	}
+	if result.Details.Category == "" {
+		result.Details.Category = user.BusinessCategoryName
+	}
+	result.Private = user.IsPrivate
+
+	if scraper.config.ParseStoryLink && !result.Private {
+		stories, err := scraper.fetchStories(ctx, user.Id)
+		if err != nil {
+			return result, err
+		}
+		result.Stories = stories
+		result.StoryLink = strings.Join(StoryLinks(stories), ",")
+	}
+	return result, nil
+}
+
+func (scraper *HttpScraper) Close() {
+	scraper.client.CloseIdleConnections()
+}
+
+type webProfileInfo struct {
+	Data struct {
+		User *struct {
+			Id                   string `json:"id"`
+			Username             string `json:"username"`
+			FullName             string `json:"full_name"`
+			Biography            string `json:"biography"`
+			ExternalUrl          string `json:"external_url"`
+			IsVerified           bool   `json:"is_verified"`
+			IsPrivate            bool   `json:"is_private"`
+			CategoryName         string `json:"category_name"`
+			BusinessCategoryName string `json:"business_category_name"`
+			EdgeFollowedBy       struct {
+				Count int `json:"count"`
+			} `json:"edge_followed_by"`
+			EdgeFollow struct {
+				Count int `json:"count"`
+			} `json:"edge_follow"`
+			EdgeOwnerToTimelineMedia struct {
+				Count int `json:"count"`
+			} `json:"edge_owner_to_timeline_media"`
+		} `json:"user"`
+	} `json:"data"`
+}
+
+type reelsMedia struct {
+	Reels map[string]struct {
+		Items []storyItemJson `json:"items"`
+	} `json:"reels"`
+}
+
+// fetchStories 取博主当前的快拍
+func (scraper *HttpScraper) fetchStories(ctx context.Context, userId string) ([]StoryItem, error) {
+	var reels reelsMedia
+	if err := scraper.getJson(ctx, reelsMediaPath+"?reel_ids="+url.QueryEscape(userId), &reels); err != nil {
+		return nil, err
+	}
+	items := make([]StoryItem, 0)
+	for _, item := range reels.Reels[userId].Items {
+		items = append(items, item.toStoryItem())
+	}
+	return items, nil
+}
+
+// apiError 接口失败时返回的 {"message": "...", "status": "fail"}
+type apiError struct {
+	Message string `json:"message"`
+	Status  string `json:"status"`
+	Spam    bool   `json:"spam"`
+}
+
+func (scraper *HttpScraper) getJson(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scraper.BaseUrl+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", scraper.fingerprint.UserAgent)
+	if scraper.fingerprint.AcceptLanguage != "" {
+		req.Header.Set("Accept-Language", scraper.fingerprint.AcceptLanguage)
+	}
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("X-IG-App-ID", instagramWebAppId)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Referer", scraper.BaseUrl+"/")
+	for _, cookie := range scraper.client.Jar.Cookies(req.URL) {
+		if cookie.Name == "csrftoken" {
+			req.Header.Set("X-CSRFToken", cookie.Value)
+		}
+	}
+
+	resp, err := scraper.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if isProxyError(err) {
+			err = errors.Wrap(ErrProxyFailed, err.Error())
+			scraper.proxy.reportError(err)
+			return err
+		}
+		return errors.Wrap(ErrPageTimeout, err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxApiResponseSize))
+	if err != nil {
+		return errors.Wrap(ErrPageTimeout, err.Error())
+	}
+	if err := checkApiResponse(resp, body); err != nil {
+		log.Errorf("[HttpScraper] %s get %s: %v", scraper.Account.Username, path, err)
+		scraper.proxy.reportError(err)
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return errors.Wrapf(ErrPageUnavailable, "can not decode %s: %v", path, err)
+	}
+	return nil
+}
+
+// checkApiResponse 把接口的失败响应映射成与浏览器后端相同的错误
+func checkApiResponse(resp *http.Response, body []byte) error {
+	var failure apiError
+	_ = json.Unmarshal(body, &failure)
+	location := resp.Header.Get("Location")
+
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return errors.Wrap(ErrProxyFailed, "proxy authentication required")
+	case failure.Message == "checkpoint_required" || failure.Message == "challenge_required" || strings.Contains(location, "/challenge"):
+		return newAccountError(ErrUserUnusable, AccountReasonHelpConfirm)
+	case failure.Message == "login_required" || strings.Contains(location, "/accounts/login"):
+		return ErrNeedLogin
+	case resp.StatusCode == http.StatusTooManyRequests || failure.Spam:
+		return newAccountError(ErrUserUnusable, AccountReasonRateLimited)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return ErrNeedLogin
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrProfileNotFound
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return errors.Wrapf(ErrPageTimeout, "http status %d", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return errors.Wrapf(ErrPageUnavailable, "http status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// usernameFromUrl 从 https://www.instagram.com/<username>/ 中取出用户名
+func usernameFromUrl(profileUrl string) string {
+	parsedUrl, err := url.Parse(strings.TrimSpace(profileUrl))
+	if err != nil {
+		return ""
+	}
+	path := strings.Trim(parsedUrl.Path, "/")
+	if path == "" {
+		return ""
+	}
+	return strings.Split(path, "/")[0]
+}
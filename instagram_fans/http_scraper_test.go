@@ -0,0 +1,206 @@
+package instagram_fans
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// newInstagramStandIn 模拟网页版接口, 只有 sessionid=valid 的请求视为已登录
+func newInstagramStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+	writeJson := func(w http.ResponseWriter, status int, body interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+
+	// loggedOut 请求过 expired 之后服务端让 Cookie 失效
+	var loggedOut atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/accounts/current_user/", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("sessionid"); err != nil || cookie.Value != "valid" || loggedOut.Load() {
+			w.Header().Set("Location", "/accounts/login/")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{"user": map[string]string{"username": "lun"}, "status": "ok"})
+	})
+	mux.HandleFunc("/api/v1/users/web_profile_info/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-IG-App-ID") != instagramWebAppId || r.Header.Get("X-CSRFToken") != "token" {
+			writeJson(w, http.StatusBadRequest, apiError{Message: "useragent mismatch", Status: "fail"})
+			return
+		}
+		switch r.URL.Query().Get("username") {
+		case "blogger":
+			writeJson(w, http.StatusOK, map[string]interface{}{
+				"data": map[string]interface{}{"user": map[string]interface{}{
+					"id":                           "42",
+					"username":                     "blogger",
+					"full_name":                    "Blogger",
+					"biography":                    "bio",
+					"external_url":                 "https://linktr.ee/blogger",
+					"is_verified":                  true,
+					"category_name":                nil,
+					"business_category_name":       "Creators & Celebrities",
+					"edge_followed_by":             map[string]int{"count": 12345},
+					"edge_follow":                  map[string]int{"count": 12},
+					"edge_owner_to_timeline_media": map[string]int{"count": 300},
+				}},
+				"status": "ok",
+			})
+		case "expired":
+			loggedOut.Store(true)
+			writeJson(w, http.StatusUnauthorized, apiError{Message: "login_required", Status: "fail"})
+		case "forbidden":
+			writeJson(w, http.StatusForbidden, apiError{Status: "fail"})
+		case "checkpoint":
+			writeJson(w, http.StatusBadRequest, apiError{Message: "checkpoint_required", Status: "fail"})
+		case "limited":
+			writeJson(w, http.StatusTooManyRequests, apiError{Message: "Please wait a few minutes before you try again.", Status: "fail"})
+		default:
+			writeJson(w, http.StatusNotFound, map[string]interface{}{"data": map[string]interface{}{"user": nil}, "status": "ok"})
+		}
+	})
+	mux.HandleFunc("/api/v1/feed/reels_media/", func(w http.ResponseWriter, r *http.Request) {
+		sticker := func(link string) map[string]interface{} {
+			return map[string]interface{}{"story_link": map[string]string{"url": link}}
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{
+			"reels": map[string]interface{}{r.URL.Query().Get("reel_ids"): map[string]interface{}{
+				"items": []interface{}{
+					map[string]interface{}{"story_link_stickers": []interface{}{sticker("https://l.instagram.com/?u=https%3A%2F%2Fexample.com%2Fshop&e=AT0")}},
+					map[string]interface{}{"story_link_stickers": []interface{}{sticker("https://example.com/shop"), sticker("https://example.org")}},
+					map[string]interface{}{},
+				},
+			}},
+			"status": "ok",
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestHttpScraper(t *testing.T, server *httptest.Server, cookie string) (Scraper, error) {
+	t.Helper()
+	appContext := &AppContext{Config: &Config{ParseFansCount: true, ParseStoryLink: true, HttpBaseUrl: server.URL}}
+	fingerprint := &Fingerprint{Engine: BrowserChromium, UserAgent: desktopUserAgent(BrowserChromium, "", "linux"), AcceptLanguage: "en-US,en;q=0.9"}
+	return NewHttpScraper(appContext, &Account{Username: "lun", SessionCookie: Secret(cookie), Fingerprint: fingerprint})
+}
+
+func TestHttpScraperSendsAccountFingerprint(t *testing.T) {
+	standIn := newInstagramStandIn(t)
+	var mutex sync.Mutex
+	headers := make([]http.Header, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		headers = append(headers, r.Header.Clone())
+		mutex.Unlock()
+		standIn.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	appContext := newTestContext(t)
+	appContext.Config.HttpBaseUrl = server.URL
+	appContext.AccountDb.Table(appContext.Config.AccountTable).Create(&Account{Username: "lun", Password: "psw"})
+	account := appContext.Accounts.FindAccount(appContext.MachineCode)
+	account.SessionCookie = Secret("sessionid=valid; csrftoken=token")
+
+	// 账号还没有指纹时生成一份并保存, 之后浏览器后端使用同一份
+	scraper, err := NewHttpScraper(appContext, account)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	defer scraper.Close()
+	if _, err := scraper.FetchProfile(context.Background(), "https://www.instagram.com/blogger"); err != nil {
+		t.Fatalf("fetch profile: %v", err)
+	}
+	reloaded := appContext.Accounts.FindAccount(appContext.MachineCode)
+	if reloaded.Fingerprint == nil || !reflect.DeepEqual(reloaded.Fingerprint, account.Fingerprint) {
+		t.Fatalf("fingerprint should be saved, got %+v, want %+v", reloaded.Fingerprint, account.Fingerprint)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(headers) == 0 {
+		t.Fatalf("stand-in got no requests")
+	}
+	for _, header := range headers {
+		if header.Get("User-Agent") != account.Fingerprint.UserAgent || header.Get("Accept-Language") != account.Fingerprint.AcceptLanguage {
+			t.Fatalf("request headers %v, want fingerprint %+v", header, account.Fingerprint)
+		}
+	}
+}
+
+func TestHttpScraperFetchProfile(t *testing.T) {
+	server := newInstagramStandIn(t)
+	scraper, err := newTestHttpScraper(t, server, "sessionid=valid; csrftoken=token; ds_user_id=1")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	defer scraper.Close()
+
+	profile, err := scraper.FetchProfile(context.Background(), "https://www.instagram.com/blogger/?hl=en")
+	if err != nil {
+		t.Fatalf("fetch profile: %v", err)
+	}
+	want := ProfileResult{FansCount: 12345, FansCountExact: true, FollowingCount: 12, PostCount: 300, StoryLink: "https://example.com/shop,https://example.org",
+		Details: &ProfileDetails{FullName: "Blogger", Biography: "bio", ExternalUrl: "https://linktr.ee/blogger", IsVerified: true, Category: "Creators & Celebrities"}}
+	if len(profile.Stories) != 3 || profile.Stories[0].Links[0].Url != "https://l.instagram.com/?u=https%3A%2F%2Fexample.com%2Fshop&e=AT0" {
+		t.Fatalf("unexpected stories %+v", profile.Stories)
+	}
+	profile.Stories = nil
+	if !reflect.DeepEqual(profile, want) {
+		t.Fatalf("got %+v, want %+v", profile, want)
+	}
+}
+
+func TestHttpScraperMapsErrors(t *testing.T) {
+	server := newInstagramStandIn(t)
+	scraper, err := newTestHttpScraper(t, server, "sessionid=valid; csrftoken=token")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	defer scraper.Close()
+
+	cases := []struct {
+		username string
+		want     error
+		reason   AccountStatusReason
+	}{
+		{username: "missing", want: ErrProfileNotFound},
+		// Cookie 仍然有效时单个主页的 403 只算这个博主打不开
+		{username: "forbidden", want: ErrPageUnavailable},
+		{username: "checkpoint", want: ErrUserUnusable, reason: AccountReasonHelpConfirm},
+		{username: "limited", want: ErrUserUnusable, reason: AccountReasonRateLimited},
+		{username: "expired", want: ErrNeedLogin},
+	}
+	for _, item := range cases {
+		_, err := scraper.FetchProfile(context.Background(), "https://www.instagram.com/"+item.username)
+		if !errors.Is(err, item.want) || AccountErrorReason(err) != item.reason {
+			t.Errorf("%s got %v, want %v(%s)", item.username, err, item.want, item.reason)
+		}
+	}
+}
+
+func TestHttpScraperRejectsInvalidSession(t *testing.T) {
+	server := newInstagramStandIn(t)
+	cases := map[string]AccountStatusReason{
+		"":                                   AccountReasonLoginFailed,
+		"csrftoken=token":                    AccountReasonLoginFailed,
+		"sessionid=expired; csrftoken=token": AccountReasonSessionExpired,
+	}
+	for cookie, reason := range cases {
+		_, err := newTestHttpScraper(t, server, cookie)
+		if !errors.Is(err, ErrUserInvalid) || AccountErrorReason(err) != reason {
+			t.Errorf("cookie %q got %v, want %s", cookie, err, reason)
+		}
+	}
+}
@@ -5,8 +5,6 @@ import (
 	"github.com/pkg/errors"
 	"github.com/playwright-community/playwright-go"
 	"net/url"
-	"regexp"
-	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -18,6 +16,10 @@ var (
 	ErrUserUnusable    = errors.New("user is unusable")
 	ErrPageUnavailable = errors.New("page is unavailable")
 	ErrPageTimeout     = errors.New("page timeout")
+	// 以下是博主主页本身的问题, 与账号无关, 换号也抓不到
+	ErrProfileNotFound   = errors.New("profile not found")
+	ErrProfilePrivate    = errors.New("profile is private")
+	ErrProfileRestricted = errors.New("profile is restricted")
 
 	PageTimeOut = time.Duration(60)
 
@@ -25,6 +27,9 @@ var (
 	suspendAccountText    = "We suspended your account"
 	helpConfirmText       = "Help us confirm it"
 	pageNotValidText      = "Sorry, this page isn't available."
+	pageLoadErrorText     = "the page could not be loaded"
+	ageRestrictedText     = "years old or over to see this profile"
+	regionRestrictedText  = "available in your country"
 	homeSelector          = `svg[aria-label="Home"]`
 	dismissSelector       = `role=button >> text=Dismiss`
 	usernameInputSelector = "input[name='username']"
@@ -60,32 +65,92 @@ func init() {
 	bodyElementCondition = ElementCondition{Selector: bodySelector}
 }
 
-func NewBrowser(pw *playwright.Playwright) (*playwright.Browser, error) {
-	browser, err := pw.Chromium.Launch(playwright.BrowserTypeLaunchOptions{
-		Headless: playwright.Bool(false),
-	})
+// 配置 browserEngine 可选的浏览器
+const (
+	BrowserChromium = "chromium"
+	BrowserFirefox  = "firefox"
+	BrowserWebkit   = "webkit"
+)
+
+// NewBrowser 按配置启动浏览器, 配置了 browserCdpUrl 时连接已经在运行的 Chromium
+func NewBrowser(pw *playwright.Playwright, config *Config) (*playwright.Browser, error) {
+	browserType, err := browserTypeOf(pw, config.BrowserEngineName())
 	if err != nil {
-		log.Fatalf("Can not launch Browser, %v", err)
+		return nil, err
 	}
-	return &browser, err
-}
 
-func NewPage(browser *playwright.Browser) (*playwright.Page, error) {
-	contextOptions := playwright.BrowserNewContextOptions{
-		Locale: playwright.String("en-US"), // 设置语言为简体中文
+	var browser playwright.Browser
+	if config.BrowserCdpUrl != "" {
+		browser, err = browserType.ConnectOverCDP(config.BrowserCdpUrl, playwright.BrowserTypeConnectOverCDPOptions{
+			SlowMo: browserSlowMo(config),
+		})
+	} else {
+		browser, err = browserType.Launch(browserLaunchOptions(config))
 	}
-	context, err := (*browser).NewContext(contextOptions)
 	if err != nil {
-		log.Fatalf("failed to set local")
+		log.Errorf("Can not launch Browser, %v", err)
 		return nil, err
 	}
+	return &browser, nil
+}
+
+func browserTypeOf(pw *playwright.Playwright, engine string) (playwright.BrowserType, error) {
+	switch engine {
+	case BrowserChromium:
+		return pw.Chromium, nil
+	case BrowserFirefox:
+		return pw.Firefox, nil
+	case BrowserWebkit:
+		return pw.WebKit, nil
+	}
+	return nil, errors.Errorf("unknown browser engine %q", engine)
+}
 
-	page, err := context.NewPage()
+// browserLaunchOptions showBrowser 为 false 时无头运行, 可以在没有图形界面的服务器上使用
+func browserLaunchOptions(config *Config) playwright.BrowserTypeLaunchOptions {
+	options := playwright.BrowserTypeLaunchOptions{
+		Headless: playwright.Bool(!config.ShowBrowser),
+		Args:     config.BrowserArgs,
+		SlowMo:   browserSlowMo(config),
+	}
+	if config.BrowserExecutablePath != "" {
+		options.ExecutablePath = playwright.String(config.BrowserExecutablePath)
+	}
+	return options
+}
+
+func browserSlowMo(config *Config) *float64 {
+	if config.BrowserSlowMoMillis <= 0 {
+		return nil
+	}
+	return playwright.Float(float64(config.BrowserSlowMoMillis))
+}
+
+// NewPage 从浏览器池取一个新的上下文并打开页面, state 不为 nil 时恢复之前保存的 cookie 和 localStorage
+func NewPage(pool *BrowserPool, state *playwright.StorageState, proxy *playwright.Proxy, fingerprint *Fingerprint) (*PooledContext, *playwright.Page, error) {
+	contextOptions := playwright.BrowserNewContextOptions{
+		Locale: playwright.String("en-US"), // 设置语言为英文
+		Proxy:  proxy,
+	}
+	if fingerprint != nil {
+		fingerprint.applyTo(&contextOptions)
+	}
+	if state != nil {
+		contextOptions.StorageState = state.ToOptionalStorageState()
+	}
+	context, err := pool.NewContext(contextOptions)
 	if err != nil {
-		log.Fatalf("Can not create Page, %v", err)
+		log.Errorf("Can not create browser context, %v", err)
+		return nil, nil, err
+	}
 
+	page, err := context.Context.NewPage()
+	if err != nil {
+		log.Errorf("Can not create Page, %v", err)
+		context.Close()
+		return nil, nil, err
 	}
-	return &page, err
+	return context, &page, nil
 }
 
 func LogInToInstagram(account *Account, page *playwright.Page) error {
@@ -93,6 +158,9 @@ func LogInToInstagram(account *Account, page *playwright.Page) error {
 		Timeout: playwright.Float(float64(time.Second * PageTimeOut / time.Millisecond)),
 	}); err != nil {
 		log.Errorf("[Login] Can not go to Login Page, %v", err)
+		if isProxyError(err) {
+			return errors.Wrap(ErrProxyFailed, err.Error())
+		}
 		return err
 	}
 
@@ -105,21 +173,26 @@ func Login(account *Account, page *playwright.Page) error {
 		inputName := "input[name='username']"
 		if err := (*page).Fill(inputName, account.Username); err != nil {
 			log.Errorf("[Login] Can not fill username, %v", err)
-			return ErrUserInvalid
+			return newAccountError(ErrUserInvalid, AccountReasonLoginFailed)
 		}
 		time.Sleep(1 * time.Second)
 
+		password, err := account.RevealPassword()
+		if err != nil {
+			log.Errorf("[Login] Can not decrypt password of %s, %v", account.Username, err)
+			return newAccountError(ErrUserUnusable, AccountReasonDecryptFailed)
+		}
 		inputPass := "input[name='password']"
-		if err := (*page).Fill(inputPass, account.Password); err != nil {
+		if err := (*page).Fill(inputPass, password); err != nil {
 			log.Errorf("[Login] Can not fill password, %v", err)
-			return ErrUserInvalid
+			return newAccountError(ErrUserInvalid, AccountReasonLoginFailed)
 		}
 		time.Sleep(1 * time.Second)
 
 		submitBtn := "button[type='submit']"
 		if err := (*page).Click(submitBtn); err != nil {
 			log.Errorf("[Login] Can not click submit btn, %v", err)
-			return ErrUserInvalid
+			return newAccountError(ErrUserInvalid, AccountReasonLoginFailed)
 		}
 
 		time.Sleep(10 * time.Second)
@@ -140,28 +213,68 @@ func Login(account *Account, page *playwright.Page) error {
 }
 
 func GetFansCount(pageRef *playwright.Page, websiteUrl string, username string) (int, error) {
+	profile, err := GetProfile(pageRef, websiteUrl, username)
+	return profile.FollowerCount, err
+}
+
+// GetProfile 打开博主主页, 优先解析页面内嵌的 JSON 和 og:description, 都没有时再读 followers 链接的文字
+func GetProfile(pageRef *playwright.Page, websiteUrl string, username string) (ProfileData, error) {
 	var page = *pageRef
 	maxCount := 2
+	profile := newProfileData(usernameFromUrl(websiteUrl))
 
 	for i := 0; i < maxCount; i++ {
 		if _, err := page.Goto(websiteUrl, playwright.PageGotoOptions{
 			Timeout: playwright.Float(float64(time.Second * PageTimeOut / time.Millisecond)),
 		}); err != nil {
 			log.Errorf("[GetFansCount] Can not go to user page, %v", err)
-			return -1, ErrUserInvalid
+			profile.FollowerCount = -1
+			if isProxyError(err) {
+				return profile, errors.Wrap(ErrProxyFailed, err.Error())
+			}
+			return profile, newAccountError(ErrUserInvalid, AccountReasonPageError)
 		}
 
 		fillCond, err := CommonHandleCondition(pageRef, followersCondition, i, maxCount, username, "fans_count")
 
 		if err != nil {
-			return -2, err
+			return profile, err
 		}
 
 		if fillCond == followersCondition {
+			content, err := page.Content()
+			if err != nil {
+				log.Errorf("[GetFansCount] Can not read content, %v", err)
+			} else if extracted, err := ExtractProfile(content, profile.Username); err == nil {
+				extracted.Private = extracted.Private || isPrivateProfilePage(content, profile.Username)
+				if extracted.Details == nil || extracted.Details.Partial {
+					log.Infof("[GetProfile] %s has no embedded profile json, only name, biography and link are read from the page", profile.Username)
+				}
+				// og:description 只有 12.3K 这类缩写时, 先读页面上的精确值再接受缩写
+				if !extracted.FollowerCountExact {
+					if count, ok := exactFollowerCountFromDom(page, PageLocale(content)); ok {
+						extracted.FollowerCount = count
+						extracted.FollowerCountExact = true
+					}
+				}
+				return extracted, nil
+			}
+			profile.Private = isPrivateProfilePage(content, profile.Username)
+			profile.Details = profileDetailsFromHtml(content, profile.Username)
+			// 账号有时会拿到 pt-BR / ru / zh 等语言的页面, 按页面语言解析数量
+			locale := PageLocale(content)
+
 			elements, err := page.QuerySelectorAll(followersSelector)
 			if err != nil {
 				log.Errorf("[getFansCount] could not query selector: %v", err)
-				return -2, ErrUserUnusable
+				return profile, newAccountError(ErrUserUnusable, AccountReasonPageError)
+			}
+
+			if count, ok := exactFollowerCountFromDom(page, locale); ok {
+				profile.FollowerCount = count
+				profile.FollowerCountExact = true
+				profile.Source = ProfileSourceDom
+				return profile, nil
 			}
 
 			for _, element := range elements {
@@ -170,29 +283,58 @@ func GetFansCount(pageRef *playwright.Page, websiteUrl string, username string)
 					log.Errorf("could not get text content: %v", err)
 				}
 
-				parts := strings.Split(textContent, " ")
-
-				countStr := parts[0]
+				countStr := ExtractCountText(textContent)
 
-				count, err := ParseFollowerCount(countStr)
+				count, err := ParseFollowerCount(countStr, locale)
 				if err != nil {
 					log.Errorf("---- falied to convert %s to int(%v)", countStr, err)
 					continue
 				}
-				return count, nil
+				profile.FollowerCount = count
+				profile.FollowerCountExact = IsExactCount(countStr)
+				profile.Source = ProfileSourceDom
+				return profile, nil
 			}
 		}
 	}
 
-	return -2, errors.Errorf("No followers found")
+	return profile, errors.Errorf("No followers found")
+}
+
+// exactFollowerCountFromDom followers 链接里的 span 的 title 属性是精确粉丝数, 文字是 12.3K 这类缩写
+func exactFollowerCountFromDom(page playwright.Page, locale string) (int, bool) {
+	elements, err := page.QuerySelectorAll(followersSelector)
+	if err != nil {
+		return 0, false
+	}
+	for _, element := range elements {
+		titleElement, err := element.QuerySelector("span[title]")
+		if err != nil || titleElement == nil {
+			continue
+		}
+		title, err := titleElement.GetAttribute("title")
+		if err != nil || !IsExactCount(title) {
+			continue
+		}
+		if count, err := ParseFollowerCount(title, locale); err == nil {
+			return count, true
+		}
+	}
+	return 0, false
 }
 
 func GetStoriesLink(pageRef *playwright.Page, webSiteUrl string, username string) (string, error) {
+	stories, err := GetStories(pageRef, webSiteUrl, username)
+	return strings.Join(StoryLinks(stories), ","), err
+}
+
+// GetStories 打开博主的快拍页面, 解析页面内嵌 JSON 里的快拍
+func GetStories(pageRef *playwright.Page, webSiteUrl string, username string) ([]StoryItem, error) {
 	page := *pageRef
 
 	storiesLink := findStoriesLink(webSiteUrl)
 	if storiesLink == "" {
-		return "", nil
+		return nil, nil
 	}
 
 	maxCount := 2
@@ -201,31 +343,58 @@ func GetStoriesLink(pageRef *playwright.Page, webSiteUrl string, username string
 			Timeout: playwright.Float(float64(time.Second * PageTimeOut / time.Millisecond)),
 		}); err != nil {
 			log.Printf("[GetStoriesLink] Can not go to stories page, %v", err)
-			return "", nil
+			if isProxyError(err) {
+				return nil, errors.Wrap(ErrProxyFailed, err.Error())
+			}
+			return nil, nil
 		}
 
 		fillCond, err := CommonHandleCondition(pageRef, bodyElementCondition, i, maxCount, username, "story_link")
 		log.Infof("[GetStoriesLink] Condition %v, err %v", fillCond, err)
+		// CommonHandleCondition 只在确认是账号问题时返回 AccountError, 其他错误(超时、主页状态、需要登录)原样返回
 		if err != nil {
-			return "", ErrUserInvalid
+			return nil, err
 		}
 
 		if fillCond == bodyElementCondition {
 			content, err := page.Content()
 			if err != nil {
 				log.Printf("[GetStoriesLink] Can not read content, %v", err)
-				return "", err
+				return nil, err
 			}
-
-			lines := strings.Split(content, "\n")
-			result := parseStoryLikes(lines)
-			if len(result) == 0 {
-				return "", err
+			items := ParseStoryItems(content)
+			if len(items) == 0 && isPrivateProfilePage(content, usernameFromUrl(webSiteUrl)) {
+				return nil, ErrProfilePrivate
 			}
-			return strings.Join(result, ","), nil
+			return items, nil
+		}
+	}
+	return nil, errors.Errorf("No stories found")
+}
+
+// privateAccountTexts 私密主页上的提示, 账号拿到的页面可能是其他语言
+var privateAccountTexts = []string{
+	"This account is private",
+	"Esta conta é privada",    // pt-BR
+	"Esta cuenta es privada",  // es
+	"Dieses Konto ist privat", // de
+	"Ce compte est privé",     // fr
+	"Это закрытый аккаунт",    // ru
+	"这是私密帐户",                  // zh-CN
+	"這是私人帳號",                  // zh-TW
+}
+
+// isPrivateProfilePage 页面内嵌了 username 的 JSON 时以其中的 is_private 为准, 否则查找各语言的私密提示
+func isPrivateProfilePage(content string, username string) bool {
+	if profile, ok := extractProfileFromJson(content, username); ok {
+		return profile.Private
+	}
+	for _, text := range privateAccountTexts {
+		if strings.Contains(content, text) {
+			return true
 		}
 	}
-	return "", errors.Errorf("No stories found")
+	return false
 }
 
 func CommonHandleCondition(page *playwright.Page, testCond Condition, curIdx int, maxCount int, userName string, tag string) (Condition, error) {
@@ -245,36 +414,42 @@ func CommonHandleCondition(page *playwright.Page, testCond Condition, curIdx int
 
 	log.Infof("[CommonHandleCondition.%s] Condition: %v, err: %v, account:%s", tag, cond, err, userName)
 	if err != nil {
-		return nil, ErrPageTimeout
+		return nil, profileErrorAfterTimeout(*page)
 	}
 	if cond == testCond {
 		return testCond, nil
 	}
 
-	if cond == passwordIncorrectCondition ||
-		cond == suspicionsLoginCondition ||
-		cond == suspendedAccountCondition ||
-		cond == httpErrorCondition {
-		return nil, ErrUserInvalid
+	if cond == passwordIncorrectCondition {
+		return nil, newAccountError(ErrUserInvalid, AccountReasonWrongPassword)
+	}
+	if cond == suspicionsLoginCondition {
+		return nil, newAccountError(ErrUserInvalid, AccountReasonSuspiciousLogin)
+	}
+	if cond == suspendedAccountCondition {
+		return nil, newAccountError(ErrUserInvalid, AccountReasonSuspended)
+	}
+	if cond == httpErrorCondition {
+		return nil, newAccountError(ErrUserInvalid, AccountReasonHttpError)
 	}
 
 	if cond == pageNotValidCondition {
-		return nil, ErrPageUnavailable
+		return nil, ErrProfileNotFound
 	}
 
 	if cond == helpConfirmCondition {
-		return nil, ErrUserUnusable
+		return nil, newAccountError(ErrUserUnusable, AccountReasonHelpConfirm)
 	}
 
 	if cond == dismissSelectorCondition {
 		dismissButton, err := (*page).QuerySelector(dismissSelector)
 		if err != nil {
-			return nil, ErrUserInvalid
+			return nil, newAccountError(ErrUserInvalid, AccountReasonPageError)
 		}
 		if dismissButton != nil {
 			if err := dismissButton.Click(); err != nil {
 				log.Error("[CommonHandleCondition] Can not click dismiss button")
-				return nil, ErrUserInvalid
+				return nil, newAccountError(ErrUserInvalid, AccountReasonPageError)
 			}
 			time.Sleep(time.Duration(5) * time.Second)
 			return nil, nil
@@ -282,33 +457,32 @@ func CommonHandleCondition(page *playwright.Page, testCond Condition, curIdx int
 	}
 
 	if cond == usernameInputCondition {
+		// 打开主页或快拍时出现登录表单, 说明恢复的登录状态已经失效
+		if testCond != homeCondition {
+			return nil, ErrNeedLogin
+		}
 		if curIdx == maxCount-1 {
-			return nil, ErrUserInvalid
+			return nil, newAccountError(ErrUserInvalid, AccountReasonLoginFailed)
 		}
 		return nil, nil
 	}
 	return nil, nil
 }
 
-func parseStoryLikes(lines []string) []string {
-	result := make([]string, 0)
-
-	for _, line := range lines {
-		if strings.Contains(line, "\"story_link\"") {
-			links := parseStoryLinks(line)
-			if len(links) == 0 {
-				continue
-			}
-
-			for _, link := range links {
-				parsedLink := parseLink(link)
-				if !slices.Contains(result, parsedLink) {
-					result = append(result, parsedLink)
-				}
-			}
-		}
+// profileErrorAfterTimeout 等不到 testCond 后再看页面是否是受限或加载失败的提示.
+// 这些文字很短, 正常主页的简介和帖子里也可能出现, 所以不能和 testCond 一起等
+func profileErrorAfterTimeout(page playwright.Page) error {
+	content, err := page.Content()
+	if err != nil {
+		return ErrPageTimeout
 	}
-	return result
+	switch {
+	case strings.Contains(content, ageRestrictedText), strings.Contains(content, regionRestrictedText):
+		return ErrProfileRestricted
+	case strings.Contains(content, pageLoadErrorText):
+		return ErrPageUnavailable
+	}
+	return ErrPageTimeout
 }
 
 func findStoriesLink(site string) string {
@@ -320,19 +494,6 @@ func findStoriesLink(site string) string {
 	return parsedUrl.Scheme + "://" + parsedUrl.Host + "/" + newPath
 }
 
-func parseStoryLinks(link string) []string {
-	re := regexp.MustCompile(`"story_link":{"url":"(.*?)"}`)
-
-	matches := re.FindAllStringSubmatch(link, -1)
-	result := make([]string, len(matches))
-	for idx, match := range matches {
-		if len(match) >= 2 {
-			result[idx] = match[1]
-		}
-	}
-	return result
-}
-
 func parseLink(link string) string {
 	linkUrl, err := url.Parse(link)
 	if err != nil {
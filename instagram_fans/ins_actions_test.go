@@ -0,0 +1,94 @@
+package instagram_fans
+
+import (
+	"github.com/pkg/errors"
+	"github.com/playwright-community/playwright-go"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProfilePage 返回固定的页面内容, selectors 之外的元素等一会儿后超时
+type fakeProfilePage struct {
+	playwright.Page
+	content   string
+	selectors map[string]bool
+}
+
+func (page *fakeProfilePage) Goto(url string, options ...playwright.PageGotoOptions) (playwright.Response, error) {
+	return nil, nil
+}
+
+func (page *fakeProfilePage) Content() (string, error) {
+	return page.content, nil
+}
+
+func (page *fakeProfilePage) WaitForSelector(selector string, options ...playwright.PageWaitForSelectorOptions) (playwright.ElementHandle, error) {
+	if page.selectors[selector] {
+		return &fakeElementHandle{}, nil
+	}
+	time.Sleep(200 * time.Millisecond)
+	return nil, errors.Errorf("timeout waiting for %s", selector)
+}
+
+func (page *fakeProfilePage) QuerySelectorAll(selector string) ([]playwright.ElementHandle, error) {
+	return nil, nil
+}
+
+type fakeElementHandle struct {
+	playwright.ElementHandle
+}
+
+func TestGetProfileIgnoresRestrictionTextInBio(t *testing.T) {
+	content := strings.Replace(readFixture(t, "testdata/profile_og.html"),
+		"Atriz &amp; cantora", "Shows available in your country soon", 1)
+	var page playwright.Page = &fakeProfilePage{
+		content:   content,
+		selectors: map[string]bool{followersSelector: true, bodySelector: true},
+	}
+
+	profile, err := GetProfile(&page, "https://www.instagram.com/mariaclara.oficial/", "lun")
+	if err != nil {
+		t.Fatalf("a loaded profile must not be treated as restricted, got %v", err)
+	}
+	if profile.FollowerCount != 12345 || profile.Details == nil || !strings.Contains(profile.Details.Biography, "available in your country") {
+		t.Fatalf("unexpected profile %+v", profile)
+	}
+}
+
+func TestCommonHandleConditionDetectsRestrictedAfterTimeout(t *testing.T) {
+	tests := []struct {
+		content string
+		want    error
+	}{
+		{content: "<body>You must be 18 years old or over to see this profile</body>", want: ErrProfileRestricted},
+		{content: "<body>This profile isn't available in your country</body>", want: ErrProfileRestricted},
+		{content: "<body>Sorry, the page could not be loaded.</body>", want: ErrPageUnavailable},
+		{content: "<body></body>", want: ErrPageTimeout},
+	}
+	for _, test := range tests {
+		var page playwright.Page = &fakeProfilePage{content: test.content, selectors: map[string]bool{bodySelector: true}}
+		if _, err := CommonHandleCondition(&page, followersCondition, 0, 2, "lun", "fans_count"); err != test.want {
+			t.Fatalf("%s: got %v, want %v", test.content, err, test.want)
+		}
+	}
+}
+
+func TestGetStoriesKeepsPageErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		want    error
+		reason  AccountStatusReason
+	}{
+		{content: "<body>Help us confirm it's you</body>", want: ErrUserUnusable, reason: AccountReasonHelpConfirm},
+		{content: "<body>Sorry, the page could not be loaded.</body>", want: ErrPageUnavailable},
+		{content: "<body></body>", want: ErrPageTimeout},
+	}
+	for _, test := range tests {
+		var page playwright.Page = &fakeProfilePage{content: test.content}
+		_, err := GetStories(&page, "https://www.instagram.com/mariaclara.oficial/", "lun")
+		if !errors.Is(err, test.want) || AccountErrorReason(err) != test.reason {
+			t.Fatalf("%s: got %v, want %v(%s)", test.content, err, test.want, test.reason)
+		}
+	}
+}
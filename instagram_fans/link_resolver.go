@@ -0,0 +1,257 @@
+package instagram_fans
+
+import (
+	"context"
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrRedirectLoop     = errors.New("redirect loop")
+	// ErrUnsafeLink 链接不是 http(s), 或者指向内网、本机地址
+	ErrUnsafeLink = errors.New("unsafe link")
+)
+
+const (
+	linkCacheSize = 10000
+	linkCacheTTL  = 24 * time.Hour
+)
+
+// linkResolverAgent 跟随外链时使用的 UA, 外链与账号无关, 用本机的默认桌面 Chrome
+var linkResolverAgent = desktopUserAgent(BrowserChromium, "", runtime.GOOS)
+
+// cgnatNetwork 运营商级 NAT 地址段, net.IP.IsPrivate 不包含
+var cgnatNetwork = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// LinkResolution 一个链接跳转后的结果, Chain 依次记录经过的地址, 第一个是原始链接, 最后一个是 FinalUrl
+type LinkResolution struct {
+	Url      string
+	FinalUrl string
+	Chain    []string
+}
+
+// LinkResolver 跟随 bit.ly / linktr.ee 这类短链的 HTTP 跳转, 找到最终地址。
+// 同一个域名的请求之间至少间隔 domainInterval, 结果在进程内缓存 cacheTTL, 最多 cacheSize 条。
+// 每一跳都只允许 http(s), 连接时检查解析出的 IP, 不访问内网和本机地址
+type LinkResolver struct {
+	client         *http.Client
+	maxHops        int
+	domainInterval time.Duration
+	cacheSize      int
+	cacheTTL       time.Duration
+
+	mutex       sync.Mutex
+	cache       map[string]cachedResolution
+	nextRequest map[string]time.Time
+}
+
+type cachedResolution struct {
+	resolution LinkResolution
+	expiresAt  time.Time
+}
+
+func NewLinkResolver(maxHops int, timeout time.Duration, domainInterval time.Duration) *LinkResolver {
+	dialer := &net.Dialer{Timeout: timeout, Control: checkDialAddress}
+	return &LinkResolver{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+			// 自己逐跳处理, 才能记录跳转链和限制每个域名的请求频率
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxHops:        maxHops,
+		domainInterval: domainInterval,
+		cacheSize:      linkCacheSize,
+		cacheTTL:       linkCacheTTL,
+		cache:          make(map[string]cachedResolution),
+		nextRequest:    make(map[string]time.Time),
+	}
+}
+
+// checkDialAddress 建立连接前检查 DNS 解析后的地址, 跳转和 DNS 重绑定都绕不过去
+func checkDialAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isInternalIP(ip) {
+		return errors.Wrapf(ErrUnsafeLink, "refuse to connect to %s", address)
+	}
+	return nil
+}
+
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || cgnatNetwork.Contains(ip)
+}
+
+// Resolve 跟随 link 的跳转。出错时返回已经走过的跳转链, FinalUrl 为最后一个能访问到的地址
+func (resolver *LinkResolver) Resolve(ctx context.Context, link string) (LinkResolution, error) {
+	if cached, ok := resolver.cached(link); ok {
+		return cached, nil
+	}
+
+	resolution := LinkResolution{Url: link, FinalUrl: link, Chain: []string{link}}
+	current := link
+	for hop := 0; ; hop++ {
+		next, err := resolver.nextHop(ctx, current)
+		if err != nil {
+			return resolution, err
+		}
+		if next == "" {
+			break
+		}
+		if slices.Contains(resolution.Chain, next) {
+			return resolution, errors.Wrap(ErrRedirectLoop, next)
+		}
+		if hop >= resolver.maxHops {
+			return resolution, errors.Wrapf(ErrTooManyRedirects, "more than %d hops from %s", resolver.maxHops, link)
+		}
+		resolution.Chain = append(resolution.Chain, next)
+		resolution.FinalUrl = next
+		current = next
+	}
+
+	resolver.store(link, resolution)
+	return resolution, nil
+}
+
+func (resolver *LinkResolver) cached(link string) (LinkResolution, bool) {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	entry, ok := resolver.cache[link]
+	if !ok {
+		return LinkResolution{}, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(resolver.cache, link)
+		return LinkResolution{}, false
+	}
+	return entry.resolution, true
+}
+
+// store 缓存解析结果, 缓存满了先清掉过期的, 仍然满时丢掉最早过期的一条
+func (resolver *LinkResolver) store(link string, resolution LinkResolution) {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	now := time.Now()
+	if _, ok := resolver.cache[link]; !ok && len(resolver.cache) >= resolver.cacheSize {
+		oldest := ""
+		for key, entry := range resolver.cache {
+			if now.After(entry.expiresAt) {
+				delete(resolver.cache, key)
+				continue
+			}
+			if oldest == "" || entry.expiresAt.Before(resolver.cache[oldest].expiresAt) {
+				oldest = key
+			}
+		}
+		if len(resolver.cache) >= resolver.cacheSize {
+			delete(resolver.cache, oldest)
+		}
+	}
+	resolver.cache[link] = cachedResolution{resolution: resolution, expiresAt: now.Add(resolver.cacheTTL)}
+}
+
+// nextHop 请求 link 一次, 是跳转时返回跳转的目标地址, 否则返回空字符串
+func (resolver *LinkResolver) nextHop(ctx context.Context, link string) (string, error) {
+	linkUrl, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	if err := checkLinkScheme(linkUrl); err != nil {
+		return "", err
+	}
+	if err := resolver.wait(ctx, linkUrl.Hostname()); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", linkResolverAgent)
+	resp, err := resolver.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 || location == "" {
+		return "", nil
+	}
+	next, err := linkUrl.Parse(location)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid redirect location %s", location)
+	}
+	if err := checkLinkScheme(next); err != nil {
+		return "", err
+	}
+	return next.String(), nil
+}
+
+func checkLinkScheme(linkUrl *url.URL) error {
+	if linkUrl.Scheme != "http" && linkUrl.Scheme != "https" {
+		return errors.Wrapf(ErrUnsafeLink, "unsupported scheme in %s", linkUrl)
+	}
+	return nil
+}
+
+// wait 按域名限速, 预约下一个可以请求的时间后再等待, 不占用锁
+func (resolver *LinkResolver) wait(ctx context.Context, domain string) error {
+	resolver.mutex.Lock()
+	now := time.Now()
+	at := resolver.nextRequest[domain]
+	if at.Before(now) {
+		at = now
+	}
+	resolver.nextRequest[domain] = at.Add(resolver.domainInterval)
+	resolver.mutex.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ResolveStories 解析快拍里所有外链的最终地址, 单个链接失败只记录日志
+func (resolver *LinkResolver) ResolveStories(ctx context.Context, stories []StoryItem) {
+	for i := range stories {
+		for j := range stories[i].Links {
+			sticker := &stories[i].Links[j]
+			if sticker.Link == "" {
+				continue
+			}
+			resolution, err := resolver.Resolve(ctx, sticker.Link)
+			if err != nil {
+				log.Errorf("[ResolveStories] Can not resolve %s, %v", sticker.Link, err)
+				if ctx.Err() != nil {
+					return
+				}
+				// 第一跳就失败时没有任何结果可以记录
+				if len(resolution.Chain) < 2 {
+					continue
+				}
+			}
+			sticker.FinalLink = resolution.FinalUrl
+			sticker.RedirectChain = resolution.Chain
+		}
+	}
+}
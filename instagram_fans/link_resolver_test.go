@@ -0,0 +1,137 @@
+package instagram_fans
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newRedirectServer 按 routes 返回 302, 没有配置的路径返回 200, 并统计请求次数
+func newRedirectServer(t *testing.T, routes map[string]string, hits *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits != nil {
+			atomic.AddInt32(hits, 1)
+		}
+		if location, ok := routes[r.URL.Path]; ok {
+			http.Redirect(w, r, location, http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestLinkResolver 测试服务器都在本机, 换掉拒绝本机地址的连接
+func newTestLinkResolver(maxHops int, domainInterval time.Duration) *LinkResolver {
+	resolver := NewLinkResolver(maxHops, time.Second, domainInterval)
+	resolver.client.Transport = &http.Transport{}
+	return resolver
+}
+
+func TestLinkResolverFollowsChain(t *testing.T) {
+	shop := newRedirectServer(t, map[string]string{"/go": "/landing"}, nil)
+	shortener := newRedirectServer(t, map[string]string{"/abc": shop.URL + "/go"}, nil)
+
+	resolver := newTestLinkResolver(5, 0)
+	resolution, err := resolver.Resolve(context.Background(), shortener.URL+"/abc")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	want := []string{shortener.URL + "/abc", shop.URL + "/go", shop.URL + "/landing"}
+	if !reflect.DeepEqual(resolution.Chain, want) || resolution.FinalUrl != shop.URL+"/landing" {
+		t.Fatalf("got %+v, want chain %v", resolution, want)
+	}
+}
+
+func TestLinkResolverStopsOnLoopAndMaxHops(t *testing.T) {
+	server := newRedirectServer(t, map[string]string{"/a": "/b", "/b": "/a", "/1": "/2", "/2": "/3", "/3": "/4"}, nil)
+	resolver := newTestLinkResolver(2, 0)
+
+	resolution, err := resolver.Resolve(context.Background(), server.URL+"/a")
+	if !errors.Is(err, ErrRedirectLoop) || resolution.FinalUrl != server.URL+"/b" {
+		t.Fatalf("got %+v, %v, want redirect loop", resolution, err)
+	}
+
+	resolution, err = resolver.Resolve(context.Background(), server.URL+"/1")
+	if !errors.Is(err, ErrTooManyRedirects) || len(resolution.Chain) != 3 {
+		t.Fatalf("got %+v, %v, want too many redirects", resolution, err)
+	}
+}
+
+func TestLinkResolverCachesAndRateLimits(t *testing.T) {
+	var hits int32
+	server := newRedirectServer(t, map[string]string{"/short": "/final"}, &hits)
+	interval := 100 * time.Millisecond
+	resolver := newTestLinkResolver(5, interval)
+
+	start := time.Now()
+	stories := []StoryItem{{Links: []StoryLinkSticker{{Link: server.URL + "/short"}}}, {Links: []StoryLinkSticker{{Link: server.URL + "/short"}}}}
+	resolver.ResolveStories(context.Background(), stories)
+	// 同一个域名的两次请求之间至少间隔 interval
+	if elapsed := time.Since(start); elapsed < interval {
+		t.Fatalf("two requests to one domain took %v, want at least %v", elapsed, interval)
+	}
+	if hits != 2 {
+		t.Fatalf("got %d requests, want 2 because the second link is cached", hits)
+	}
+	for _, story := range stories {
+		sticker := story.Links[0]
+		if sticker.FinalLink != server.URL+"/final" || len(sticker.RedirectChain) != 2 {
+			t.Fatalf("unexpected sticker %+v", sticker)
+		}
+	}
+}
+
+func TestLinkResolverRejectsUnsafeLinks(t *testing.T) {
+	internal := newRedirectServer(t, nil, nil)
+	public := newRedirectServer(t, map[string]string{"/file": "file:///etc/passwd"}, nil)
+	resolver := NewLinkResolver(5, time.Second, 0)
+
+	// 默认的连接拒绝本机和内网地址, 无论是原始链接还是跳转目标
+	for _, link := range []string{internal.URL + "/", "http://169.254.169.254/latest/meta-data", "http://[::1]:1/"} {
+		if _, err := resolver.Resolve(context.Background(), link); !errors.Is(err, ErrUnsafeLink) {
+			t.Fatalf("resolve %s got %v, want ErrUnsafeLink", link, err)
+		}
+	}
+
+	testResolver := newTestLinkResolver(5, 0)
+	resolution, err := testResolver.Resolve(context.Background(), public.URL+"/file")
+	if !errors.Is(err, ErrUnsafeLink) || resolution.FinalUrl != public.URL+"/file" {
+		t.Fatalf("redirect to file scheme got %+v, %v, want ErrUnsafeLink", resolution, err)
+	}
+	if _, err := testResolver.Resolve(context.Background(), "ftp://example.com/a"); !errors.Is(err, ErrUnsafeLink) {
+		t.Fatalf("ftp link got %v, want ErrUnsafeLink", err)
+	}
+}
+
+func TestLinkResolverCacheLimits(t *testing.T) {
+	var hits int32
+	server := newRedirectServer(t, nil, &hits)
+	resolver := newTestLinkResolver(5, 0)
+	resolver.cacheSize = 2
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		resolver.Resolve(context.Background(), server.URL+path)
+	}
+	if len(resolver.cache) != 2 {
+		t.Fatalf("cache holds %d entries, want 2", len(resolver.cache))
+	}
+	if _, ok := resolver.cached(server.URL + "/a"); ok {
+		t.Fatalf("the oldest entry should be evicted")
+	}
+
+	// 过期的结果重新请求
+	resolver.cacheTTL = -time.Second
+	resolver.Resolve(context.Background(), server.URL+"/d")
+	before := atomic.LoadInt32(&hits)
+	resolver.Resolve(context.Background(), server.URL+"/d")
+	if atomic.LoadInt32(&hits) != before+1 {
+		t.Fatalf("expired entry should be resolved again")
+	}
+}
@@ -0,0 +1,70 @@
+package instagram_fans
+
+import (
+	"github.com/charmbracelet/log"
+	"github.com/muesli/termenv"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	// dsnPasswordPattern 匹配 MySQL DSN 里的 user:password@tcp(、@unix( 或 @/, 密码里可以有 '@' 和 ':'
+	dsnPasswordPattern = regexp.MustCompile(`([A-Za-z0-9_.\-]+:)(\S*?)(@(?:tcp|unix)?\(|@/)`)
+	// urlPasswordPattern 匹配 URL 里的 ://user:password@, 密码到 host 前最后一个 '@' 为止
+	urlPasswordPattern = regexp.MustCompile(`(://[^\s/@:]+:)([^\s/]*)(@)`)
+	// secretFieldPattern 匹配 psw=xxx, "password":"xxx", sessionid=xxx; 等写法
+	secretFieldPattern = regexp.MustCompile(`(?i)(\b(?:psw|passwd|password|sessionid|session_cookie|sessioncookie|csrftoken|totp_secret|totp|secret)"?\s*[=:]\s*"?)([^\s",;&}\]]+)`)
+
+	registeredSecrets sync.Map
+)
+
+// InstallRedactLogger 让默认 logger 的所有日志都经过脱敏后再输出到 stderr, 终端下保留颜色。
+// 程序启动时调用一次
+func InstallRedactLogger() {
+	log.SetOutput(NewRedactWriter(os.Stderr))
+	log.SetColorProfile(termenv.NewOutput(os.Stderr).ColorProfile())
+}
+
+// RegisterSecret 登记一个需要在日志里遮蔽的具体值, 例如解密后的密码
+func RegisterSecret(secret string) {
+	if len(secret) < 4 {
+		return
+	}
+	registeredSecrets.Store(secret, struct{}{})
+}
+
+// Redact 遮蔽字符串里的 DSN 密码、密码/cookie/TOTP 字段以及登记过的敏感值
+func Redact(text string) string {
+	text = RedactDSN(text)
+	text = secretFieldPattern.ReplaceAllString(text, "${1}"+maskedSecret)
+	registeredSecrets.Range(func(key, _ interface{}) bool {
+		text = strings.ReplaceAll(text, key.(string), maskedSecret)
+		return true
+	})
+	return text
+}
+
+// RedactDSN 遮蔽 DSN 和 URL 里的密码
+func RedactDSN(dsn string) string {
+	dsn = dsnPasswordPattern.ReplaceAllString(dsn, "${1}"+maskedSecret+"${3}")
+	return urlPasswordPattern.ReplaceAllString(dsn, "${1}"+maskedSecret+"${3}")
+}
+
+type redactWriter struct {
+	out io.Writer
+}
+
+// NewRedactWriter 返回一个输出前先脱敏的 writer
+func NewRedactWriter(out io.Writer) io.Writer {
+	return &redactWriter{out: out}
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	if _, err := w.out.Write([]byte(Redact(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,642 @@
+package instagram_fans
+
+import (
+	"fmt"
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	MigrationScopeBlogger = "blogger"
+	MigrationScopeAccount = "account"
+)
+
+// Migration 一次版本化的表结构变更, table 为该变更作用的表名(来自配置)。
+// MySQL 的 DDL 会隐式提交, 外面的事务保证不了原子性, 所以 Up 必须可以重复执行:
+// 建表、加列、加索引前先检查是否已经存在, 回填数据只改还没有回填过的行
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB, table string) error
+	Down    func(tx *gorm.DB, table string) error
+}
+
+// SchemaMigration 记录每个数据库里已经执行过的变更
+type SchemaMigration struct {
+	Scope     string    `gorm:"primaryKey;type:varchar(32)"`
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"type:varchar(128)"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+type migrationScope struct {
+	name       string
+	db         *gorm.DB
+	table      string
+	migrations []Migration
+}
+
+// bloggerMigrations 作用于 config.Table 所在的数据库
+var bloggerMigrations = []Migration{
+	{Version: 1, Name: "create_user_table", Up: createUserTable, Down: dropTable},
+	{Version: 2, Name: "add_user_scrape_status", Up: addUserScrapeStatus, Down: dropColumns("scrape_status")},
+	{Version: 3, Name: "add_user_claim_lease", Up: addUserClaimLease, Down: dropColumns("claimed_by", "lease_expires_at")},
+	{Version: 4, Name: "add_user_indexes", Up: addUserIndexes, Down: dropUserIndexes},
+	{Version: 5, Name: "create_blogger_snapshot", Up: createBloggerSnapshot, Down: dropBloggerSnapshot},
+	{Version: 6, Name: "add_fans_count_exact", Up: addFansCountExact, Down: dropFansCountExact},
+	{Version: 7, Name: "create_story_link", Up: createStoryLink, Down: dropStoryLink},
+	{Version: 8, Name: "add_story_link_final", Up: addStoryLinkFinal, Down: dropStoryLinkFinal},
+	{Version: 9, Name: "add_user_profile_details", Up: addUserProfileDetails, Down: dropColumns(userProfileDetailColumns...)},
+	{Version: 10, Name: "add_user_scrape_detail", Up: addUserScrapeDetail, Down: dropColumns("scrape_detail")},
+	{Version: 11, Name: "add_user_scrape_attempts", Up: addUserScrapeAttempts, Down: dropColumns("scrape_attempts")},
+}
+
+// accountMigrations 作用于 config.AccountTable 所在的数据库
+var accountMigrations = []Migration{
+	{Version: 1, Name: "create_account_table", Up: createAccountTable, Down: dropTable},
+	{Version: 2, Name: "add_account_indexes", Up: addAccountIndexes, Down: dropAccountIndexes},
+	{Version: 3, Name: "add_account_status_reason", Up: addAccountStatusReason, Down: dropColumns("status_reason", "status_changed_at")},
+	{Version: 4, Name: "create_account_audit", Up: createAccountAudit, Down: dropAccountAudit},
+	{Version: 5, Name: "widen_account_password", Up: widenAccountPassword, Down: keepAccountPasswordWidth},
+	{Version: 6, Name: "add_account_session_cookie", Up: addAccountSessionCookie, Down: dropColumns("session_cookie")},
+	{Version: 7, Name: "create_proxy", Up: createProxy, Down: dropProxy},
+	{Version: 8, Name: "add_account_fingerprint", Up: addAccountFingerprint, Down: dropColumns("fingerprint")},
+}
+
+func migrationScopes(appContext *AppContext) []migrationScope {
+	return []migrationScope{
+		{name: MigrationScopeBlogger, db: appContext.Db, table: appContext.Config.Table, migrations: bloggerMigrations},
+		{name: MigrationScopeAccount, db: appContext.AccountDb, table: appContext.Config.AccountTable, migrations: accountMigrations},
+	}
+}
+
+// MigrateUp 依次执行所有未执行的变更
+func MigrateUp(appContext *AppContext) error {
+	for _, scope := range migrationScopes(appContext) {
+		if err := scope.up(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateDown 回滚最近一次执行的变更, scopeName 为空时在所有库里找最近的一次。
+// applied_at 相同(MySQL 只精确到秒)时按 MigrateUp 的执行顺序, 后执行的库先回滚
+func MigrateDown(appContext *AppContext, scopeName string) error {
+	var target *migrationScope
+	var last *SchemaMigration
+	scopes := migrationScopes(appContext)
+	for idx := range scopes {
+		scope := &scopes[idx]
+		if scopeName != "" && scope.name != scopeName {
+			continue
+		}
+		applied, err := scope.lastApplied()
+		if err != nil {
+			return err
+		}
+		if applied == nil {
+			continue
+		}
+		if last == nil || !applied.AppliedAt.Before(last.AppliedAt) {
+			target, last = scope, applied
+		}
+	}
+	if target == nil {
+		log.Infof("No migration to roll back")
+		return nil
+	}
+	return target.down(last)
+}
+
+// MigrationStatus 返回每个库里已经执行过的变更
+func MigrationStatus(appContext *AppContext) ([]SchemaMigration, error) {
+	var result []SchemaMigration
+	for _, scope := range migrationScopes(appContext) {
+		applied, err := scope.applied()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, applied...)
+	}
+	return result, nil
+}
+
+func (scope *migrationScope) applied() ([]SchemaMigration, error) {
+	if err := scope.db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, errors.Wrap(err, "Can not create schema_migrations")
+	}
+	var applied []SchemaMigration
+	if err := scope.db.Where("scope = ?", scope.name).Order("version ASC").Find(&applied).Error; err != nil {
+		return nil, errors.Wrap(err, "Can not read schema_migrations")
+	}
+	return applied, nil
+}
+
+func (scope *migrationScope) lastApplied() (*SchemaMigration, error) {
+	applied, err := scope.applied()
+	if err != nil || len(applied) == 0 {
+		return nil, err
+	}
+	return &applied[len(applied)-1], nil
+}
+
+func (scope *migrationScope) up() error {
+	applied, err := scope.applied()
+	if err != nil {
+		return err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, item := range applied {
+		done[item.Version] = true
+	}
+
+	migrations := append([]Migration(nil), scope.migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for _, migration := range migrations {
+		if done[migration.Version] {
+			continue
+		}
+		log.Infof("[Migrate] %s %d_%s on %s", scope.name, migration.Version, migration.Name, scope.table)
+		err := scope.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx, scope.table); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{
+				Scope:     scope.name,
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return errors.Wrapf(err, "migration %s %d_%s failed", scope.name, migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+func (scope *migrationScope) down(applied *SchemaMigration) error {
+	var migration *Migration
+	for idx := range scope.migrations {
+		if scope.migrations[idx].Version == applied.Version {
+			migration = &scope.migrations[idx]
+		}
+	}
+	if migration == nil {
+		return errors.Errorf("unknown migration %s %d_%s", scope.name, applied.Version, applied.Name)
+	}
+	if migration.Down == nil {
+		return errors.Errorf("migration %s %d_%s can not be rolled back", scope.name, migration.Version, migration.Name)
+	}
+
+	log.Infof("[Migrate] roll back %s %d_%s on %s", scope.name, migration.Version, migration.Name, scope.table)
+	err := scope.db.Transaction(func(tx *gorm.DB) error {
+		if err := migration.Down(tx, scope.table); err != nil {
+			return err
+		}
+		return tx.Where("scope = ? AND version = ?", scope.name, migration.Version).Delete(&SchemaMigration{}).Error
+	})
+	return errors.Wrapf(err, "roll back %s %d_%s failed", scope.name, migration.Version, migration.Name)
+}
+
+// addColumns 给表补上 model 中缺少的列, 返回是否真的新增了列
+func addColumns(tx *gorm.DB, table string, model interface{}, fields ...string) (bool, error) {
+	migrator := tx.Table(table).Migrator()
+	added := false
+	for _, field := range fields {
+		if migrator.HasColumn(model, field) {
+			continue
+		}
+		if err := migrator.AddColumn(model, field); err != nil {
+			return added, errors.Wrapf(err, "Can not add %s to %s", field, table)
+		}
+		added = true
+	}
+	return added, nil
+}
+
+func dropColumns(columns ...string) func(tx *gorm.DB, table string) error {
+	return func(tx *gorm.DB, table string) error {
+		migrator := tx.Table(table).Migrator()
+		for _, column := range columns {
+			if !migrator.HasColumn(table, column) {
+				continue
+			}
+			// sqlite 驱动的 DropColumn 只接受 model, 这里直接执行 DROP COLUMN(SQLite 3.35 起支持)
+			if err := tx.Exec("ALTER TABLE " + quote(tx, table) + " DROP COLUMN " + quote(tx, column)).Error; err != nil {
+				return errors.Wrapf(err, "Can not drop %s from %s", column, table)
+			}
+		}
+		return nil
+	}
+}
+
+func dropTable(tx *gorm.DB, table string) error {
+	return tx.Migrator().DropTable(table)
+}
+
+type tableIndex struct {
+	suffix  string
+	columns []string
+	unique  bool
+}
+
+func (index tableIndex) name(table string) string {
+	return fmt.Sprintf("idx_%s_%s", table, index.suffix)
+}
+
+func createIndexes(tx *gorm.DB, table string, indexes []tableIndex) error {
+	migrator := tx.Migrator()
+	for _, index := range indexes {
+		name := index.name(table)
+		if migrator.HasIndex(table, name) {
+			continue
+		}
+		kind := "INDEX"
+		if index.unique {
+			kind = "UNIQUE INDEX"
+		}
+		columns := make([]string, len(index.columns))
+		for idx, column := range index.columns {
+			columns[idx] = quote(tx, column)
+		}
+		sql := fmt.Sprintf("CREATE %s %s ON %s (%s)", kind, quote(tx, name), quote(tx, table), strings.Join(columns, ", "))
+		if err := tx.Exec(sql).Error; err != nil {
+			return errors.Wrapf(err, "Can not create index %s", name)
+		}
+	}
+	return nil
+}
+
+func dropIndexes(tx *gorm.DB, table string, indexes []tableIndex) error {
+	migrator := tx.Migrator()
+	for _, index := range indexes {
+		name := index.name(table)
+		if !migrator.HasIndex(table, name) {
+			continue
+		}
+		if err := migrator.DropIndex(table, name); err != nil {
+			return errors.Wrapf(err, "Can not drop index %s", name)
+		}
+	}
+	return nil
+}
+
+func quote(tx *gorm.DB, name string) string {
+	return tx.Statement.Quote(name)
+}
+
+func createUserTable(tx *gorm.DB, table string) error {
+	// 表结构与最初手工创建的 user 表一致
+	type user struct {
+		Id        int    `gorm:"primaryKey"`
+		Url       string `gorm:"type:varchar(512);not null"`
+		StoryLink string `gorm:"type:text;default:null"`
+		FansCount int    `gorm:"default:-1"`
+	}
+	migrator := tx.Table(table).Migrator()
+	if migrator.HasTable(table) {
+		return nil
+	}
+	return migrator.CreateTable(&user{})
+}
+
+func addUserScrapeStatus(tx *gorm.DB, table string) error {
+	type user struct {
+		ScrapeStatus string `gorm:"column:scrape_status;type:varchar(16);default:pending"`
+	}
+	if _, err := addColumns(tx, table, &user{}, "ScrapeStatus"); err != nil {
+		return err
+	}
+
+	// 新列默认是 pending(对应 fans_count = -1 待抓取)。-2 可能是正在抓取也可能是抓取失败, 统一视为失败,
+	// 其余为已有粉丝数。只改还是 pending 的行, 加列后回填失败时重新执行不会覆盖已经回填的状态
+	backfill := []struct {
+		where  string
+		status ScrapeStatus
+	}{
+		{"fans_count = -2", ScrapeStatusFailed},
+		{"fans_count >= 0", ScrapeStatusDone},
+	}
+	for _, item := range backfill {
+		result := tx.Table(table).Where(item.where).Where("scrape_status = ?", ScrapeStatusPending).Update("scrape_status", item.status)
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "Can not backfill scrape_status(%s)", item.status)
+		}
+	}
+	return nil
+}
+
+func addUserClaimLease(tx *gorm.DB, table string) error {
+	type user struct {
+		ClaimedBy      string     `gorm:"column:claimed_by;type:varchar(64);default:null"`
+		LeaseExpiresAt *time.Time `gorm:"column:lease_expires_at;default:null"`
+	}
+	_, err := addColumns(tx, table, &user{}, "ClaimedBy", "LeaseExpiresAt")
+	return err
+}
+
+var userIndexes = []tableIndex{
+	{suffix: "url", columns: []string{"url"}, unique: true},
+	{suffix: "fans_count", columns: []string{"fans_count"}},
+	{suffix: "scrape_status", columns: []string{"scrape_status", "id"}},
+	{suffix: "lease", columns: []string{"scrape_status", "lease_expires_at"}},
+}
+
+func addUserIndexes(tx *gorm.DB, table string) error {
+	return createIndexes(tx, table, userIndexes)
+}
+
+func dropUserIndexes(tx *gorm.DB, table string) error {
+	return dropIndexes(tx, table, userIndexes)
+}
+
+func createBloggerSnapshot(tx *gorm.DB, _ string) error {
+	type bloggerSnapshot struct {
+		Id          int       `gorm:"primaryKey"`
+		UserId      int       `gorm:"column:user_id;index:idx_snapshot_user_time,priority:1"`
+		FansCount   *int      `gorm:"column:fans_count"`
+		StoryLink   *string   `gorm:"column:story_link;type:text"`
+		Account     string    `gorm:"column:account;type:varchar(128)"`
+		MachineCode string    `gorm:"column:machine_code;type:varchar(64)"`
+		CreatedAt   time.Time `gorm:"column:created_at;index:idx_snapshot_user_time,priority:2"`
+	}
+	migrator := tx.Table(BloggerSnapshot{}.TableName()).Migrator()
+	if migrator.HasTable(BloggerSnapshot{}.TableName()) {
+		return nil
+	}
+	return migrator.CreateTable(&bloggerSnapshot{})
+}
+
+func dropBloggerSnapshot(tx *gorm.DB, _ string) error {
+	return tx.Migrator().DropTable(BloggerSnapshot{}.TableName())
+}
+
+func addFansCountExact(tx *gorm.DB, table string) error {
+	type user struct {
+		FansCountExact bool `gorm:"column:fans_count_exact;default:false"`
+	}
+	if _, err := addColumns(tx, table, &user{}, "FansCountExact"); err != nil {
+		return err
+	}
+	type snapshot struct {
+		FansCountExact *bool `gorm:"column:fans_count_exact"`
+	}
+	_, err := addColumns(tx, BloggerSnapshot{}.TableName(), &snapshot{}, "FansCountExact")
+	return err
+}
+
+func dropFansCountExact(tx *gorm.DB, table string) error {
+	if err := dropColumns("fans_count_exact")(tx, table); err != nil {
+		return err
+	}
+	return dropColumns("fans_count_exact")(tx, BloggerSnapshot{}.TableName())
+}
+
+// createStoryLink 建 story_link 表, 并把 user.story_link 里逗号拼接的旧数据拆分导入。
+// (user_id, link_hash) 唯一, 导入到一半失败后重新执行时已经导入的链接会被跳过
+func createStoryLink(tx *gorm.DB, table string) error {
+	type storyLink struct {
+		Id           int       `gorm:"primaryKey"`
+		UserId       int       `gorm:"column:user_id;uniqueIndex:idx_story_link_user_hash,priority:1"`
+		RawLink      string    `gorm:"column:raw_link;type:text"`
+		ResolvedLink string    `gorm:"column:resolved_link;type:text"`
+		LinkHash     string    `gorm:"column:link_hash;type:char(64);uniqueIndex:idx_story_link_user_hash,priority:2"`
+		Domain       string    `gorm:"column:domain;type:varchar(255);index:idx_story_link_domain"`
+		StoryItemId  string    `gorm:"column:story_item_id;type:varchar(64)"`
+		FirstSeen    time.Time `gorm:"column:first_seen"`
+		LastSeen     time.Time `gorm:"column:last_seen"`
+	}
+	linkTable := BloggerStoryLink{}.TableName()
+	migrator := tx.Table(linkTable).Migrator()
+	if !migrator.HasTable(linkTable) {
+		if err := migrator.CreateTable(&storyLink{}); err != nil {
+			return err
+		}
+	}
+
+	type user struct {
+		Id        int
+		StoryLink string
+	}
+	now := time.Now()
+	var users []user
+	return tx.Table(table).Select("id, story_link").
+		Where("story_link IS NOT NULL AND story_link <> ''").
+		FindInBatches(&users, 500, func(batch *gorm.DB, _ int) error {
+			var links []*storyLink
+			for _, item := range users {
+				for _, link := range SplitJoinedStoryLinks(item.StoryLink) {
+					record := newBloggerStoryLink(item.Id, link, link, "", now)
+					links = append(links, &storyLink{UserId: record.UserId, RawLink: record.RawLink, ResolvedLink: record.ResolvedLink,
+						LinkHash: record.LinkHash, Domain: record.Domain, FirstSeen: now, LastSeen: now})
+				}
+			}
+			if len(links) == 0 {
+				return nil
+			}
+			return tx.Table(linkTable).Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error
+		}).Error
+}
+
+func dropStoryLink(tx *gorm.DB, _ string) error {
+	return tx.Migrator().DropTable(BloggerStoryLink{}.TableName())
+}
+
+func addStoryLinkFinal(tx *gorm.DB, _ string) error {
+	type storyLink struct {
+		FinalLink     string `gorm:"column:final_link;type:text"`
+		FinalDomain   string `gorm:"column:final_domain;type:varchar(255)"`
+		RedirectChain string `gorm:"column:redirect_chain;type:text"`
+	}
+	linkTable := BloggerStoryLink{}.TableName()
+	if _, err := addColumns(tx, linkTable, &storyLink{}, "FinalLink", "FinalDomain", "RedirectChain"); err != nil {
+		return err
+	}
+	return createIndexes(tx, linkTable, storyLinkFinalIndexes)
+}
+
+var storyLinkFinalIndexes = []tableIndex{
+	{suffix: "final_domain", columns: []string{"final_domain"}},
+}
+
+func dropStoryLinkFinal(tx *gorm.DB, _ string) error {
+	linkTable := BloggerStoryLink{}.TableName()
+	if err := dropIndexes(tx, linkTable, storyLinkFinalIndexes); err != nil {
+		return err
+	}
+	return dropColumns("final_link", "final_domain", "redirect_chain")(tx, linkTable)
+}
+
+var userProfileDetailColumns = []string{"following_count", "post_count", "full_name", "biography", "external_url", "is_verified", "category", "is_private"}
+
+func addUserProfileDetails(tx *gorm.DB, table string) error {
+	type user struct {
+		FollowingCount int    `gorm:"column:following_count;default:-1"`
+		PostCount      int    `gorm:"column:post_count;default:-1"`
+		FullName       string `gorm:"column:full_name;type:varchar(255);default:null"`
+		Biography      string `gorm:"column:biography;type:text;default:null"`
+		ExternalUrl    string `gorm:"column:external_url;type:text;default:null"`
+		IsVerified     bool   `gorm:"column:is_verified;default:false"`
+		Category       string `gorm:"column:category;type:varchar(255);default:null"`
+		IsPrivate      bool   `gorm:"column:is_private;default:false"`
+	}
+	_, err := addColumns(tx, table, &user{}, "FollowingCount", "PostCount", "FullName", "Biography", "ExternalUrl", "IsVerified", "Category", "IsPrivate")
+	return err
+}
+
+func addUserScrapeDetail(tx *gorm.DB, table string) error {
+	type user struct {
+		ScrapeDetail string `gorm:"column:scrape_detail;type:varchar(255);default:null"`
+	}
+	_, err := addColumns(tx, table, &user{}, "ScrapeDetail")
+	return err
+}
+
+func addUserScrapeAttempts(tx *gorm.DB, table string) error {
+	type user struct {
+		ScrapeAttempts int `gorm:"column:scrape_attempts;default:0"`
+	}
+	_, err := addColumns(tx, table, &user{}, "ScrapeAttempts")
+	return err
+}
+
+func createAccountTable(tx *gorm.DB, table string) error {
+	// 列名沿用最初手工创建的账号表: user / psw / Machine_code
+	type account struct {
+		Id          int    `gorm:"primaryKey"`
+		Username    string `gorm:"column:user;type:varchar(128);not null"`
+		Password    string `gorm:"column:psw;type:varchar(255)"`
+		Status      int    `gorm:"column:status;default:0"`
+		MachineCode string `gorm:"column:Machine_code;type:varchar(64)"`
+	}
+	migrator := tx.Table(table).Migrator()
+	if migrator.HasTable(table) {
+		return nil
+	}
+	return migrator.CreateTable(&account{})
+}
+
+var accountIndexes = []tableIndex{
+	{suffix: "user", columns: []string{"user"}, unique: true},
+	{suffix: "status", columns: []string{"status"}},
+	{suffix: "machine_code", columns: []string{"Machine_code", "status"}},
+}
+
+func addAccountIndexes(tx *gorm.DB, table string) error {
+	return createIndexes(tx, table, accountIndexes)
+}
+
+func dropAccountIndexes(tx *gorm.DB, table string) error {
+	return dropIndexes(tx, table, accountIndexes)
+}
+
+func addAccountStatusReason(tx *gorm.DB, table string) error {
+	type account struct {
+		StatusReason    string     `gorm:"column:status_reason;type:varchar(32);default:null"`
+		StatusChangedAt *time.Time `gorm:"column:status_changed_at;default:null"`
+	}
+	if _, err := addColumns(tx, table, &account{}, "StatusReason", "StatusChangedAt"); err != nil {
+		return err
+	}
+	// 已经不可用的账号从现在开始计算冷却时间, 已经有时间的行不再修改
+	return tx.Table(table).Where("status <> ? AND status_changed_at IS NULL", AccountStatusIdle).Update("status_changed_at", time.Now()).Error
+}
+
+func createAccountAudit(tx *gorm.DB, _ string) error {
+	type accountAudit struct {
+		Id          int       `gorm:"primaryKey"`
+		Account     string    `gorm:"column:account;type:varchar(128);index:idx_account_audit_account_time,priority:1"`
+		OldStatus   int       `gorm:"column:old_status"`
+		NewStatus   int       `gorm:"column:new_status"`
+		Reason      string    `gorm:"column:reason;type:varchar(32)"`
+		MachineCode string    `gorm:"column:machine_code;type:varchar(64)"`
+		BloggerUrl  string    `gorm:"column:blogger_url;type:varchar(512)"`
+		CreatedAt   time.Time `gorm:"column:created_at;index:idx_account_audit_account_time,priority:2"`
+	}
+	migrator := tx.Table(AccountAudit{}.TableName()).Migrator()
+	if migrator.HasTable(AccountAudit{}.TableName()) {
+		return nil
+	}
+	return migrator.CreateTable(&accountAudit{})
+}
+
+func dropAccountAudit(tx *gorm.DB, _ string) error {
+	return tx.Migrator().DropTable(AccountAudit{}.TableName())
+}
+
+// widenAccountPassword 加密后的密码比明文长, psw 列放宽到 512。SQLite 不限制 varchar 长度, 不需要修改
+func widenAccountPassword(tx *gorm.DB, table string) error {
+	if tx.Dialector.Name() == DriverSQLite {
+		return nil
+	}
+	type account struct {
+		Password string `gorm:"column:psw;type:varchar(512)"`
+	}
+	return tx.Table(table).Migrator().AlterColumn(&account{}, "Password")
+}
+
+// keepAccountPasswordWidth 回滚时保留 varchar(512), 改回 255 会截断已经加密的密码
+func keepAccountPasswordWidth(tx *gorm.DB, table string) error {
+	log.Warnf("[Migrate] keep psw of %s as varchar(512), narrowing it would truncate encrypted passwords", table)
+	return nil
+}
+
+func addAccountFingerprint(tx *gorm.DB, table string) error {
+	type account struct {
+		Fingerprint string `gorm:"column:fingerprint;type:text"`
+	}
+	_, err := addColumns(tx, table, &account{}, "Fingerprint")
+	return err
+}
+
+func addAccountSessionCookie(tx *gorm.DB, table string) error {
+	type account struct {
+		SessionCookie string `gorm:"column:session_cookie;type:text"`
+	}
+	_, err := addColumns(tx, table, &account{}, "SessionCookie")
+	return err
+}
+
+// createProxy 建 proxy 表, 账号表加上绑定的代理 proxy_id
+func createProxy(tx *gorm.DB, table string) error {
+	type proxy struct {
+		Id            int        `gorm:"primaryKey"`
+		Url           string     `gorm:"column:url;type:varchar(255);uniqueIndex:idx_proxy_url"`
+		Username      string     `gorm:"column:username;type:varchar(128)"`
+		Password      string     `gorm:"column:password;type:varchar(512)"`
+		Status        string     `gorm:"column:status;type:varchar(16);default:active;index:idx_proxy_status"`
+		FailCount     int        `gorm:"column:fail_count;default:0"`
+		LastError     string     `gorm:"column:last_error;type:varchar(255)"`
+		LastFailureAt *time.Time `gorm:"column:last_failure_at"`
+		LastCheckedAt *time.Time `gorm:"column:last_checked_at"`
+	}
+	migrator := tx.Table(Proxy{}.TableName()).Migrator()
+	if !migrator.HasTable(Proxy{}.TableName()) {
+		if err := migrator.CreateTable(&proxy{}); err != nil {
+			return err
+		}
+	}
+	type account struct {
+		ProxyId *int `gorm:"column:proxy_id"`
+	}
+	_, err := addColumns(tx, table, &account{}, "ProxyId")
+	return err
+}
+
+func dropProxy(tx *gorm.DB, table string) error {
+	if err := dropColumns("proxy_id")(tx, table); err != nil {
+		return err
+	}
+	return tx.Migrator().DropTable(Proxy{}.TableName())
+}
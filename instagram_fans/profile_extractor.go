@@ -0,0 +1,276 @@
+package instagram_fans
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	ProfileSourceJson = "json"
+	ProfileSourceMeta = "meta"
+	ProfileSourceDom  = "dom"
+)
+
+var (
+	ErrNoProfileData = errors.New("no profile data in page")
+
+	jsonScriptPattern = regexp.MustCompile(`(?s)<script[^>]*type="application/json"[^>]*>(.*?)</script>`)
+	metaTagPattern    = regexp.MustCompile(`(?i)<meta\s[^>]*>`)
+	metaAttrPattern   = regexp.MustCompile(`(?i)(property|name|content)\s*=\s*"([^"]*)"`)
+	// og:description 形如 "12.3K Followers, 500 Following, 80 Posts - See Instagram photos and videos from ...",
+	// 页面是其他语言时为 "1,2 mil seguidores" "12,5 тыс. подписчиков" "1.2万 位粉丝" 等
+	ogFollowersPattern = regexp.MustCompile(`(?i)(` + countToken + `)\s*(?:followers|seguidores|abonnenten|abonnés|подписчик|位粉丝|粉丝)`)
+	ogFollowingPattern = regexp.MustCompile(`(?i)(` + countToken + `)\s*(?:following|seguindo|siguiendo|seguidos|abonniert|abonnements|подписок|подписки|人关注|关注)`)
+	ogPostsPattern     = regexp.MustCompile(`(?i)(` + countToken + `)\s*(?:posts|publicações|publicaciones|beiträge|publications|публикаци|篇帖子|帖子)`)
+	// followersLinkPattern 主页上指向 /<username>/followers/ 的链接, 里面 span 的 title 是精确粉丝数
+	followersLinkPattern = regexp.MustCompile(`(?is)<a\s[^>]*href="[^"]*/followers/?"[^>]*>(.*?)</a>`)
+	titleAttrPattern     = regexp.MustCompile(`(?i)\stitle="([^"]+)"`)
+	// og:title 形如 "Maria Clara (@mariaclara.oficial) • Instagram photos and videos", 各语言都以 "昵称 (@用户名)" 开头
+	ogTitleNamePattern = regexp.MustCompile(`^\s*(.*?)\s*\(@([^)\s]+)\)`)
+	headerPattern      = regexp.MustCompile(`(?is)<header[^>]*>(.*?)</header>`)
+	// 主页头部里博主自己填写的文字(昵称、简介)都带 dir="auto"
+	autoDirTextPattern  = regexp.MustCompile(`(?is)<(span|h1|div)\s[^>]*dir="auto"[^>]*>(.*?)</(?:span|h1|div)>`)
+	externalLinkPattern = regexp.MustCompile(`(?i)<a\s[^>]*href="(https://l\.instagram\.com/[^"]+)"`)
+	htmlTagPattern      = regexp.MustCompile(`<[^>]+>`)
+	htmlBreakPattern    = regexp.MustCompile(`(?i)<br\s*/?>`)
+)
+
+// ProfileData 从博主主页 HTML 里解析出的数据, 数量为 -2 表示没有找到
+type ProfileData struct {
+	Username      string
+	FollowerCount int
+	// FollowerCountExact 粉丝数是精确值, 来自 JSON、title 属性或没有 K/M 缩写的文字
+	FollowerCountExact bool
+	FollowingCount     int
+	PostCount          int
+	// Private 私密账号, 能看到数量但看不到快拍
+	Private bool
+	// Details 昵称、简介等资料。内嵌 JSON 和 http 后端能拿到全部字段,
+	// 其他来源只能从 og:title 和主页头部补出昵称、简介和外链, Partial 为 true, 一项都没有时为 nil
+	Details *ProfileDetails
+	// Source 数据来自 json(页面内嵌的接口数据)、meta(og:description) 还是 dom(链接文字)
+	Source string
+}
+
+// ProfileDetails 博主主页上除数量以外的资料
+type ProfileDetails struct {
+	FullName    string
+	Biography   string
+	ExternalUrl string
+	IsVerified  bool
+	Category    string // 商业账号的类别, 如 "Digital creator"
+	IsPrivate   bool
+	// Partial 资料来自 meta/DOM, 空字段表示没有找到, 认证、类别和私密标记都拿不到
+	Partial bool
+}
+
+func newProfileData(username string) ProfileData {
+	return ProfileData{Username: username, FollowerCount: -2, FollowingCount: -2, PostCount: -2}
+}
+
+// ExtractProfile 先从页面内嵌的 JSON 里找 username 的数据, 找不到再解析 og:description。
+// username 为空时取 JSON 里第一个带粉丝数的用户
+func ExtractProfile(content string, username string) (ProfileData, error) {
+	if profile, ok := extractProfileFromJson(content, username); ok {
+		return profile, nil
+	}
+	if profile, ok := extractProfileFromMeta(content, username); ok {
+		return profile, nil
+	}
+	return newProfileData(username), ErrNoProfileData
+}
+
+func extractProfileFromJson(content string, username string) (ProfileData, bool) {
+	for _, match := range jsonScriptPattern.FindAllStringSubmatch(content, -1) {
+		decoder := json.NewDecoder(strings.NewReader(match[1]))
+		decoder.UseNumber()
+		var payload interface{}
+		if err := decoder.Decode(&payload); err != nil {
+			continue
+		}
+		if profile, ok := findProfileInJson(payload, username); ok {
+			return profile, true
+		}
+	}
+	return ProfileData{}, false
+}
+
+// findProfileInJson 递归查找带 follower_count 或 edge_followed_by 的用户对象
+func findProfileInJson(node interface{}, username string) (ProfileData, bool) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if profile, ok := profileFromJsonUser(value); ok && (username == "" || strings.EqualFold(profile.Username, username)) {
+			return profile, true
+		}
+		for _, child := range value {
+			if profile, ok := findProfileInJson(child, username); ok {
+				return profile, true
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			if profile, ok := findProfileInJson(child, username); ok {
+				return profile, true
+			}
+		}
+	}
+	return ProfileData{}, false
+}
+
+func profileFromJsonUser(user map[string]interface{}) (ProfileData, bool) {
+	name, _ := user["username"].(string)
+	profile := newProfileData(name)
+	profile.Source = ProfileSourceJson
+
+	profile.FollowerCount = jsonCount(user, "follower_count", "edge_followed_by")
+	if profile.FollowerCount < 0 {
+		return profile, false
+	}
+	profile.FollowerCountExact = true
+	profile.FollowingCount = jsonCount(user, "following_count", "edge_follow")
+	profile.PostCount = jsonCount(user, "media_count", "edge_owner_to_timeline_media")
+	profile.Details = profileDetailsFromJson(user)
+	profile.Private = profile.Details.IsPrivate
+	return profile, true
+}
+
+func profileDetailsFromJson(user map[string]interface{}) *ProfileDetails {
+	details := &ProfileDetails{}
+	details.FullName, _ = user["full_name"].(string)
+	details.Biography, _ = user["biography"].(string)
+	details.ExternalUrl, _ = user["external_url"].(string)
+	details.IsVerified, _ = user["is_verified"].(bool)
+	details.IsPrivate, _ = user["is_private"].(bool)
+	details.Category, _ = user["category_name"].(string)
+	if details.Category == "" {
+		details.Category, _ = user["business_category_name"].(string)
+	}
+	return details
+}
+
+// jsonCount 读取 {"follower_count": 1} 或 {"edge_followed_by": {"count": 1}} 两种写法
+func jsonCount(user map[string]interface{}, field string, edge string) int {
+	if count, ok := jsonInt(user[field]); ok {
+		return count
+	}
+	if edgeValue, ok := user[edge].(map[string]interface{}); ok {
+		if count, ok := jsonInt(edgeValue["count"]); ok {
+			return count
+		}
+	}
+	return -2
+}
+
+func jsonInt(value interface{}) (int, bool) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	count, err := strconv.Atoi(number.String())
+	return count, err == nil
+}
+
+func extractProfileFromMeta(content string, username string) (ProfileData, bool) {
+	description := findMetaContent(content, "og:description")
+	if description == "" {
+		description = findMetaContent(content, "description")
+	}
+	profile := newProfileData(username)
+	profile.Source = ProfileSourceMeta
+	locale := PageLocale(content)
+
+	followers := ogFollowersPattern.FindStringSubmatch(description)
+	if followers == nil {
+		return profile, false
+	}
+	count, err := ParseFollowerCount(followers[1], locale)
+	if err != nil {
+		return profile, false
+	}
+	profile.FollowerCount = count
+	profile.FollowerCountExact = IsExactCount(followers[1])
+	// og:description 里是 12.3K 这类缩写时, 优先使用 followers 链接上 title 属性里的精确值
+	if !profile.FollowerCountExact {
+		if exact, ok := exactFollowerCountFromHtml(content, locale); ok {
+			profile.FollowerCount = exact
+			profile.FollowerCountExact = true
+		}
+	}
+
+	if following := ogFollowingPattern.FindStringSubmatch(description); following != nil {
+		if count, err := ParseFollowerCount(following[1], locale); err == nil {
+			profile.FollowingCount = count
+		}
+	}
+	if posts := ogPostsPattern.FindStringSubmatch(description); posts != nil {
+		if count, err := ParseFollowerCount(posts[1], locale); err == nil {
+			profile.PostCount = count
+		}
+	}
+	profile.Details = profileDetailsFromHtml(content, username)
+	return profile, true
+}
+
+// profileDetailsFromHtml 页面没有内嵌 JSON 时, 从 og:title 取昵称, 从主页头部取简介和外链
+func profileDetailsFromHtml(content string, username string) *ProfileDetails {
+	details := &ProfileDetails{Partial: true}
+	if name := ogTitleNamePattern.FindStringSubmatch(findMetaContent(content, "og:title")); name != nil {
+		if username == "" || strings.EqualFold(name[2], username) {
+			details.FullName = name[1]
+		}
+	}
+	if header := headerPattern.FindStringSubmatch(content); header != nil {
+		for _, match := range autoDirTextPattern.FindAllStringSubmatch(header[1], -1) {
+			text := htmlBreakPattern.ReplaceAllString(match[2], "\n")
+			text = strings.TrimSpace(html.UnescapeString(htmlTagPattern.ReplaceAllString(text, "")))
+			if text == "" || text == details.FullName || strings.EqualFold(text, username) {
+				continue
+			}
+			details.Biography = text
+			break
+		}
+		if link := externalLinkPattern.FindStringSubmatch(header[1]); link != nil {
+			details.ExternalUrl = parseLink(html.UnescapeString(link[1]))
+		}
+	}
+	if details.FullName == "" && details.Biography == "" && details.ExternalUrl == "" {
+		return nil
+	}
+	return details
+}
+
+// exactFollowerCountFromHtml 读取 followers 链接里 span 的 title 属性, 不是精确值时返回 false
+func exactFollowerCountFromHtml(content string, locale string) (int, bool) {
+	for _, link := range followersLinkPattern.FindAllStringSubmatch(content, -1) {
+		title := titleAttrPattern.FindStringSubmatch(link[1])
+		if title == nil {
+			continue
+		}
+		text := html.UnescapeString(title[1])
+		if !IsExactCount(text) {
+			continue
+		}
+		if count, err := ParseFollowerCount(text, locale); err == nil {
+			return count, true
+		}
+	}
+	return 0, false
+}
+
+// findMetaContent 返回 property 或 name 为 key 的 meta 标签的 content
+func findMetaContent(content string, key string) string {
+	for _, tag := range metaTagPattern.FindAllString(content, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range metaAttrPattern.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(attr[1])] = attr[2]
+		}
+		if strings.EqualFold(attrs["property"], key) || strings.EqualFold(attrs["name"], key) {
+			return html.UnescapeString(attrs["content"])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package instagram_fans
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func readFixture(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read fixture %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestExtractProfileFromEmbeddedJson(t *testing.T) {
+	content := readFixture(t, "../assets/fans_count.txt")
+
+	profile, err := ExtractProfile(content, "jonatasbacciotti")
+	if err != nil {
+		t.Fatalf("extract profile: %v", err)
+	}
+	details := profile.Details
+	if details == nil || details.FullName != "Jonatas Eduardo" || !strings.HasPrefix(details.Biography, "Apostador profissional") || details.IsVerified || details.IsPrivate {
+		t.Fatalf("unexpected details %+v", details)
+	}
+	profile.Details = nil
+	want := ProfileData{Username: "jonatasbacciotti", FollowerCount: 18302, FollowerCountExact: true, FollowingCount: 1420, PostCount: 82, Source: ProfileSourceJson}
+	if profile != want {
+		t.Fatalf("got %+v, want %+v", profile, want)
+	}
+
+	if _, err := ExtractProfile(content, "someone_else"); err != ErrNoProfileData {
+		t.Fatalf("another user's data must not be used, got %v", err)
+	}
+}
+
+func TestExtractProfileFallsBackToMeta(t *testing.T) {
+	content := readFixture(t, "testdata/profile_og.html")
+
+	profile, err := ExtractProfile(content, "mariaclara.oficial")
+	if err != nil {
+		t.Fatalf("extract profile: %v", err)
+	}
+	// 没有内嵌 JSON 时昵称来自 og:title, 简介和外链来自主页头部
+	details := profile.Details
+	if details == nil || !details.Partial || details.FullName != "Maria Clara" || details.Biography != "Atriz & cantora\nContato: contato@mariaclara.com" || details.ExternalUrl != "https://linktr.ee/mariaclara" {
+		t.Fatalf("unexpected details %+v", details)
+	}
+	profile.Details = nil
+	// og:description 里是 12.3K, followers 链接的 title 属性里是精确的 12,345
+	want := ProfileData{Username: "mariaclara.oficial", FollowerCount: 12345, FollowerCountExact: true, FollowingCount: 500, PostCount: 80, Source: ProfileSourceMeta}
+	if profile != want {
+		t.Fatalf("got %+v, want %+v", profile, want)
+	}
+}
+
+func TestExtractProfileWithoutData(t *testing.T) {
+	profile, err := ExtractProfile("<html><body>Sorry, this page isn't available.</body></html>", "gone")
+	if err != ErrNoProfileData || profile.FollowerCount != -2 {
+		t.Fatalf("got %+v, %v", profile, err)
+	}
+}
+
+func TestIsExactCount(t *testing.T) {
+	for text, want := range map[string]bool{"12,345": true, "80": true, "12.3K": false, "1.5M ": false, "": false} {
+		if got := IsExactCount(text); got != want {
+			t.Errorf("IsExactCount(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestExtractProfileFromLocalizedMeta(t *testing.T) {
+	content := `<html lang="pt-BR"><head><meta property="og:description" content="1,2&nbsp;mil seguidores, 1.234 seguindo, 80 posts - Veja as fotos e vídeos do Instagram de Maria (&#064;maria)" /></head></html>`
+
+	profile, err := ExtractProfile(content, "maria")
+	if err != nil {
+		t.Fatalf("extract profile: %v", err)
+	}
+	want := ProfileData{Username: "maria", FollowerCount: 1200, FollowingCount: 1234, PostCount: 80, Source: ProfileSourceMeta}
+	if profile != want {
+		t.Fatalf("got %+v, want %+v", profile, want)
+	}
+}
+
+func TestIsPrivateProfilePage(t *testing.T) {
+	for _, content := range []string{
+		"<span>This account is private</span>",
+		"<h2>Esta conta é privada</h2>",
+		"<h2>Esta cuenta es privada</h2>",
+		"<h2>Это закрытый аккаунт</h2>",
+		"<h2>这是私密帐户</h2>",
+		`<script type="application/json">{"user":{"username":"blogger","is_private":true,"follower_count":10}}</script>`,
+	} {
+		if !isPrivateProfilePage(content, "blogger") {
+			t.Errorf("should be private: %s", content)
+		}
+	}
+	// JSON 里博主不是私密账号时, 页面其他地方出现的文字不算
+	public := `<script type="application/json">{"user":{"username":"blogger","is_private":false,"follower_count":10}}</script><p>This account is private</p>`
+	if isPrivateProfilePage(public, "blogger") {
+		t.Errorf("is_private false in json should win")
+	}
+}
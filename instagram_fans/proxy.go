@@ -0,0 +1,314 @@
+package instagram_fans
+
+import (
+	"fmt"
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+	"github.com/playwright-community/playwright-go"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrProxyFailed 代理连不上, 与账号无关, 换一个代理后账号可以继续使用
+	ErrProxyFailed = errors.New("proxy failed")
+	// ErrNoHealthyProxy 配置了代理但没有一个能通过检查
+	ErrNoHealthyProxy = errors.New("no healthy proxy")
+)
+
+// ProxyStatus 代理状态
+type ProxyStatus string
+
+const (
+	ProxyStatusActive   ProxyStatus = "active"   // 可以使用
+	ProxyStatusFailed   ProxyStatus = "failed"   // 检查或使用时失败, 冷却后重新检查
+	ProxyStatusDisabled ProxyStatus = "disabled" // 人工停用
+)
+
+// Proxy 代理, 与账号表在同一个库里。Password 是数据库里保存的密文, 只在使用时解密
+type Proxy struct {
+	Id            int         `gorm:"primaryKey"`
+	Url           string      `gorm:"column:url;type:varchar(255);uniqueIndex:idx_proxy_url"` // http://host:port 或 socks5://host:port
+	Username      string      `gorm:"column:username;type:varchar(128)"`
+	Password      Secret      `gorm:"column:password;type:varchar(512)"`
+	Status        ProxyStatus `gorm:"column:status;type:varchar(16);default:active;index:idx_proxy_status"`
+	FailCount     int         `gorm:"column:fail_count;default:0"` // 连续失败次数, 检查通过后清零
+	LastError     string      `gorm:"column:last_error;type:varchar(255)"`
+	LastFailureAt *time.Time  `gorm:"column:last_failure_at"`
+	LastCheckedAt *time.Time  `gorm:"column:last_checked_at"`
+
+	cipher *PasswordCipher
+}
+
+func (Proxy) TableName() string {
+	return "proxy"
+}
+
+// String 打印代理时不包含密码
+func (proxy Proxy) String() string {
+	return fmt.Sprintf("{url:%s user:%s status:%s fails:%d}", proxy.Url, proxy.Username, proxy.Status, proxy.FailCount)
+}
+
+// RevealPassword 解密代理密码, 明文会登记到日志脱敏列表里
+func (proxy *Proxy) RevealPassword() (string, error) {
+	password, err := proxy.cipher.Decrypt(string(proxy.Password))
+	if err == nil {
+		RegisterSecret(password)
+	}
+	return password, err
+}
+
+// PlaywrightProxy 浏览器上下文使用的代理设置
+func (proxy *Proxy) PlaywrightProxy() (*playwright.Proxy, error) {
+	password, err := proxy.RevealPassword()
+	if err != nil {
+		return nil, err
+	}
+	settings := &playwright.Proxy{Server: proxy.Url}
+	if proxy.Username != "" {
+		settings.Username = playwright.String(proxy.Username)
+		settings.Password = playwright.String(password)
+	}
+	return settings, nil
+}
+
+// ProxyUrl http 客户端使用的代理地址, 带上用户名和密码
+func (proxy *Proxy) ProxyUrl() (*url.URL, error) {
+	proxyUrl, err := url.Parse(proxy.Url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid proxy url %s", proxy.Url)
+	}
+	if proxy.Username != "" {
+		password, err := proxy.RevealPassword()
+		if err != nil {
+			return nil, err
+		}
+		proxyUrl.User = url.UserPassword(proxy.Username, password)
+	}
+	return proxyUrl, nil
+}
+
+// CheckProxy 通过代理请求 checkUrl, 连不上、代理要求认证或返回 5xx 都视为失败
+func CheckProxy(proxy *Proxy, checkUrl string, timeout time.Duration) error {
+	proxyUrl, err := proxy.ProxyUrl()
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get(checkUrl)
+	if err != nil {
+		return errors.Wrap(ErrProxyFailed, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusProxyAuthRequired || resp.StatusCode >= http.StatusInternalServerError {
+		return errors.Wrapf(ErrProxyFailed, "http status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// isProxyError 浏览器或 http 客户端的错误是否由代理引起
+func isProxyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	for _, marker := range []string{"ERR_PROXY", "ERR_TUNNEL", "ERR_SOCKS", "proxyconnect"} {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyForAccount 返回账号使用的代理。账号绑定的代理检查失败时标记为失败, 换一个绑定账号较少的可用代理。
+// 没有配置任何代理时返回 nil, 直接连接
+func ProxyForAccount(db *gorm.DB, accountTable string, account *Account, cipher *PasswordCipher, cooldown time.Duration, check func(*Proxy) error) (*Proxy, error) {
+	var proxies []*Proxy
+	err := db.Where("status = ? OR (status = ? AND last_failure_at < ?)", ProxyStatusActive, ProxyStatusFailed, time.Now().Add(-cooldown)).
+		Order("id").Find(&proxies).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(proxies) == 0 {
+		var total int64
+		if err := db.Model(&Proxy{}).Count(&total).Error; err != nil {
+			return nil, err
+		}
+		if total == 0 {
+			return nil, nil
+		}
+		return nil, ErrNoHealthyProxy
+	}
+
+	bound, err := countProxyAccounts(db, accountTable)
+	if err != nil {
+		return nil, err
+	}
+	isCurrent := func(proxy *Proxy) bool { return account.ProxyId != nil && *account.ProxyId == proxy.Id }
+	sort.SliceStable(proxies, func(i, j int) bool {
+		if isCurrent(proxies[i]) != isCurrent(proxies[j]) {
+			return isCurrent(proxies[i])
+		}
+		return bound[proxies[i].Id] < bound[proxies[j].Id]
+	})
+
+	for _, proxy := range proxies {
+		proxy.cipher = cipher
+		if err := check(proxy); err != nil {
+			log.Warnf("[ProxyForAccount] proxy %s failed the check, %v", proxy.Url, err)
+			MarkProxyFailed(db, proxy, err)
+			continue
+		}
+		markProxyHealthy(db, proxy)
+		if !isCurrent(proxy) {
+			if err := db.Table(accountTable).Where("user = ?", account.Username).Update("proxy_id", proxy.Id).Error; err != nil {
+				return nil, err
+			}
+			log.Infof("[ProxyForAccount] bind account %s to proxy %s", account.Username, proxy.Url)
+			account.ProxyId = &proxy.Id
+		}
+		return proxy, nil
+	}
+	return nil, ErrNoHealthyProxy
+}
+
+// proxyBinding 抓取后端当前使用的代理, 请求因代理失败时标记代理失败, 下次创建后端时换一个代理
+type proxyBinding struct {
+	store ProxyStore
+	proxy *Proxy
+}
+
+// bindProxy 为账号分配代理, 没有配置代理时 proxy 为 nil
+func bindProxy(appContext *AppContext, account *Account) (proxyBinding, error) {
+	binding := proxyBinding{store: appContext.Proxies}
+	if binding.store == nil {
+		return binding, nil
+	}
+	proxy, err := binding.store.ProxyForAccount(account)
+	if err != nil {
+		return binding, err
+	}
+	if proxy != nil {
+		log.Infof("account %s uses proxy %s", account.Username, proxy.Url)
+	}
+	binding.proxy = proxy
+	return binding, nil
+}
+
+// reportError err 是代理引起的时候标记代理失败
+func (binding *proxyBinding) reportError(err error) {
+	if binding.proxy == nil || !errors.Is(err, ErrProxyFailed) {
+		return
+	}
+	binding.store.MarkProxyFailed(binding.proxy, err)
+}
+
+// countProxyAccounts 每个代理绑定的账号数量
+func countProxyAccounts(db *gorm.DB, accountTable string) (map[int]int, error) {
+	var rows []struct {
+		ProxyId int
+		Count   int
+	}
+	err := db.Table(accountTable).Select("proxy_id, COUNT(*) AS count").
+		Where("proxy_id IS NOT NULL").Group("proxy_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	bound := make(map[int]int, len(rows))
+	for _, row := range rows {
+		bound[row.ProxyId] = row.Count
+	}
+	return bound, nil
+}
+
+// MarkProxyFailed 代理失败后暂时不再分配, 冷却后重新检查
+func MarkProxyFailed(db *gorm.DB, proxy *Proxy, reason error) {
+	now := time.Now()
+	message := truncateScrapeDetail(reason.Error())
+	err := db.Model(&Proxy{}).Where("id = ?", proxy.Id).Updates(map[string]interface{}{
+		"status":          ProxyStatusFailed,
+		"fail_count":      gorm.Expr("fail_count + 1"),
+		"last_error":      message,
+		"last_failure_at": now,
+		"last_checked_at": now,
+	}).Error
+	if err != nil {
+		log.Errorf("Can not mark proxy %s failed, %v", proxy.Url, err)
+		return
+	}
+	proxy.Status = ProxyStatusFailed
+	proxy.FailCount++
+	proxy.LastError = message
+	proxy.LastFailureAt = &now
+}
+
+func markProxyHealthy(db *gorm.DB, proxy *Proxy) {
+	now := time.Now()
+	err := db.Model(&Proxy{}).Where("id = ?", proxy.Id).Updates(map[string]interface{}{
+		"status":          ProxyStatusActive,
+		"fail_count":      0,
+		"last_checked_at": now,
+	}).Error
+	if err != nil {
+		log.Errorf("Can not mark proxy %s healthy, %v", proxy.Url, err)
+		return
+	}
+	proxy.Status = ProxyStatusActive
+	proxy.FailCount = 0
+	proxy.LastCheckedAt = &now
+}
+
+// AddProxy 添加或更新代理, 配置了密钥时密码加密保存
+func AddProxy(db *gorm.DB, rawUrl string, username string, password string, cipher *PasswordCipher) error {
+	proxyUrl, err := url.Parse(rawUrl)
+	if err != nil || proxyUrl.Host == "" {
+		return errors.Errorf("invalid proxy url %s", rawUrl)
+	}
+	stored := password
+	if cipher != nil && password != "" {
+		if stored, err = cipher.Encrypt(password); err != nil {
+			return err
+		}
+	}
+	proxy := Proxy{Url: rawUrl, Username: username, Password: Secret(stored), Status: ProxyStatusActive}
+	var existing Proxy
+	if err := db.Where("url = ?", rawUrl).First(&existing).Error; err == nil {
+		return db.Model(&existing).Updates(map[string]interface{}{"username": username, "password": stored, "status": ProxyStatusActive, "fail_count": 0}).Error
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return db.Create(&proxy).Error
+}
+
+// SetProxyStatus 人工启用或停用代理
+func SetProxyStatus(db *gorm.DB, rawUrl string, status ProxyStatus) error {
+	result := db.Model(&Proxy{}).Where("url = ?", rawUrl).Updates(map[string]interface{}{"status": status, "fail_count": 0})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.Errorf("proxy %s not found", rawUrl)
+	}
+	return nil
+}
+
+// FindProxies 返回所有代理
+func FindProxies(db *gorm.DB) ([]*Proxy, error) {
+	var proxies []*Proxy
+	if err := db.Order("id").Find(&proxies).Error; err != nil {
+		return nil, err
+	}
+	return proxies, nil
+}
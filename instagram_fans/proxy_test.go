@@ -0,0 +1,152 @@
+package instagram_fans
+
+import (
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestProxyServer 充当 http 代理的测试服务器, 要求 Proxy-Authorization 为 user:psw
+func newTestProxyServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:psw"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != want {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCheckProxy(t *testing.T) {
+	server := newTestProxyServer(t)
+	cipher := newTestCipher(t)
+	encrypted, err := cipher.Encrypt("psw")
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	proxy := &Proxy{Url: server.URL, Username: "user", Password: Secret(encrypted), cipher: cipher}
+	if err := CheckProxy(proxy, "http://www.instagram.com/", time.Second); err != nil {
+		t.Fatalf("check proxy with credentials: %v", err)
+	}
+
+	wrong := &Proxy{Url: server.URL, Username: "user", Password: "wrong"}
+	if err := CheckProxy(wrong, "http://www.instagram.com/", time.Second); !errors.Is(err, ErrProxyFailed) {
+		t.Fatalf("check proxy with wrong password got %v, want ErrProxyFailed", err)
+	}
+
+	server.Close()
+	if err := CheckProxy(proxy, "http://www.instagram.com/", time.Second); !errors.Is(err, ErrProxyFailed) {
+		t.Fatalf("check closed proxy got %v, want ErrProxyFailed", err)
+	}
+}
+
+func newTestProxyStore(t *testing.T, appContext *AppContext, failing map[string]bool) ProxyStore {
+	t.Helper()
+	check := func(proxy *Proxy) error {
+		if failing[proxy.Url] {
+			return ErrProxyFailed
+		}
+		return nil
+	}
+	return NewProxyStore(appContext.AccountDb, appContext.Config.AccountTable, time.Hour, nil, check)
+}
+
+func TestProxyForAccountBindsAndRotates(t *testing.T) {
+	appContext := newTestContext(t)
+	failing := map[string]bool{}
+	store := newTestProxyStore(t, appContext, failing)
+
+	account := &Account{Username: "lun", Password: "psw"}
+	if err := appContext.AccountDb.Table(appContext.Config.AccountTable).Create(account).Error; err != nil {
+		t.Fatalf("insert account: %v", err)
+	}
+	if proxy, err := store.ProxyForAccount(account); proxy != nil || err != nil {
+		t.Fatalf("no proxies got %v, %v, want direct connection", proxy, err)
+	}
+
+	for _, url := range []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"} {
+		if err := store.AddProxy(url, "", ""); err != nil {
+			t.Fatalf("add proxy: %v", err)
+		}
+	}
+	first, err := store.ProxyForAccount(account)
+	if err != nil || first == nil || first.Url != "http://10.0.0.1:8080" {
+		t.Fatalf("first proxy got %v, %v", first, err)
+	}
+	var stored Account
+	appContext.AccountDb.Table(appContext.Config.AccountTable).Where("user = ?", "lun").First(&stored)
+	if stored.ProxyId == nil || *stored.ProxyId != first.Id {
+		t.Fatalf("account should be bound to proxy %d, got %v", first.Id, stored.ProxyId)
+	}
+
+	// 绑定的代理失败后换到另一个, 失败的代理在冷却期内不再分配
+	failing["http://10.0.0.1:8080"] = true
+	second, err := store.ProxyForAccount(account)
+	if err != nil || second == nil || second.Url != "http://10.0.0.2:8080" {
+		t.Fatalf("rotated proxy got %v, %v", second, err)
+	}
+	proxies, _ := store.FindProxies()
+	if proxies[0].Status != ProxyStatusFailed || proxies[0].FailCount != 1 || proxies[0].LastFailureAt == nil {
+		t.Fatalf("failed proxy got %+v", proxies[0])
+	}
+
+	failing["http://10.0.0.2:8080"] = true
+	if proxy, err := store.ProxyForAccount(account); proxy != nil || !errors.Is(err, ErrNoHealthyProxy) {
+		t.Fatalf("all proxies failing got %v, %v, want ErrNoHealthyProxy", proxy, err)
+	}
+	if proxy, err := store.ProxyForAccount(account); proxy != nil || !errors.Is(err, ErrNoHealthyProxy) {
+		t.Fatalf("all proxies cooling down got %v, %v, want ErrNoHealthyProxy", proxy, err)
+	}
+
+	// 冷却期过后重新检查, 通过后恢复可用
+	delete(failing, "http://10.0.0.1:8080")
+	appContext.AccountDb.Model(&Proxy{}).Where("url = ?", "http://10.0.0.1:8080").Update("last_failure_at", time.Now().Add(-2*time.Hour))
+	recovered, err := store.ProxyForAccount(account)
+	if err != nil || recovered == nil || recovered.Url != "http://10.0.0.1:8080" || recovered.Status != ProxyStatusActive || recovered.FailCount != 0 {
+		t.Fatalf("recovered proxy got %v, %v", recovered, err)
+	}
+}
+
+func TestProxyForAccountPrefersLeastUsed(t *testing.T) {
+	appContext := newTestContext(t)
+	store := newTestProxyStore(t, appContext, map[string]bool{})
+	for _, url := range []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"} {
+		if err := store.AddProxy(url, "", ""); err != nil {
+			t.Fatalf("add proxy: %v", err)
+		}
+	}
+	if err := store.SetProxyStatus("http://10.0.0.3:8080", ProxyStatusDisabled); err == nil {
+		t.Fatalf("disable unknown proxy should fail")
+	}
+
+	accounts := appContext.AccountDb.Table(appContext.Config.AccountTable)
+	first := &Account{Username: "a"}
+	second := &Account{Username: "b"}
+	accounts.Create(first)
+	accounts.Create(second)
+
+	proxyA, err := store.ProxyForAccount(first)
+	if err != nil {
+		t.Fatalf("proxy for a: %v", err)
+	}
+	proxyB, err := store.ProxyForAccount(second)
+	if err != nil || proxyB.Id == proxyA.Id {
+		t.Fatalf("second account should use the other proxy, got %v, %v", proxyB, err)
+	}
+
+	// 停用后不再分配, 账号换到剩下的代理
+	if err := store.SetProxyStatus(proxyB.Url, ProxyStatusDisabled); err != nil {
+		t.Fatalf("disable proxy: %v", err)
+	}
+	if proxy, err := store.ProxyForAccount(second); err != nil || proxy.Id != proxyA.Id {
+		t.Fatalf("disabled proxy should not be used, got %v, %v", proxy, err)
+	}
+}
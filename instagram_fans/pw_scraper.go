@@ -0,0 +1,208 @@
+package instagram_fans
+
+import (
+	"context"
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+	"github.com/playwright-community/playwright-go"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// PlaywrightScraper 用 Chromium 打开博主主页抓取数据
+type PlaywrightScraper struct {
+	Context  *PooledContext
+	Page     *playwright.Page
+	Account  *Account
+	config   *Config
+	sessions *SessionStore
+	proxy    proxyBinding
+	// loggedIn 页面处于登录状态, 关闭时才保存登录状态
+	loggedIn bool
+}
+
+// NewPlaywrightScraper 启动浏览器, 有保存的登录状态时直接恢复, 否则用 account 登录
+func NewPlaywrightScraper(appContext *AppContext, account *Account) (Scraper, error) {
+	scraper := &PlaywrightScraper{Account: account, config: appContext.Config, sessions: appContext.Sessions}
+
+	var state *playwright.StorageState
+	if scraper.sessions != nil {
+		var err error
+		if state, err = scraper.sessions.Load(account.Username); err != nil {
+			log.Warnf("[NewPlaywrightScraper] Can not restore session of %s, %v", account.Username, err)
+		}
+	}
+	binding, err := bindProxy(appContext, account)
+	if err != nil {
+		return scraper, err
+	}
+	scraper.proxy = binding
+	var proxy *playwright.Proxy
+	if binding.proxy != nil {
+		if proxy, err = binding.proxy.PlaywrightProxy(); err != nil {
+			return scraper, err
+		}
+	}
+	fingerprint, err := accountFingerprint(appContext, account)
+	if err != nil {
+		return scraper, err
+	}
+	context, page, err := NewPage(appContext.Browsers, state, proxy, fingerprint)
+	if err != nil {
+		return scraper, errors.Wrap(err, "Can not create page!!!")
+	}
+	scraper.Context = context
+	scraper.Page = page
+
+	log.Infof("using account: %v", *account)
+
+	// 恢复了登录状态时不再走登录表单, 状态失效时抓取会返回 ErrNeedLogin, 再由 Relogin 登录
+	if state != nil {
+		log.Infof("[NewPlaywrightScraper] restored session of %s", account.Username)
+		scraper.loggedIn = true
+		return scraper, nil
+	}
+	if err := LogInToInstagram(account, page); err != nil {
+		log.Errorf("[NewPlaywrightScraper] Can not login to instagram!!! %v", err)
+		scraper.proxy.reportError(err)
+		return scraper, err
+	}
+	scraper.loggedIn = true
+	scraper.saveSession()
+	return scraper, nil
+}
+
+// accountFingerprint 返回账号保存的指纹, 没有时生成一份并保存, 之后每次登录都使用同一份。
+// 保存的指纹不是当前 browserEngine 生成的时候重新生成, 否则 UA 和浏览器的实际特征对不上
+func accountFingerprint(appContext *AppContext, account *Account) (*Fingerprint, error) {
+	engine := appContext.Config.BrowserEngineName()
+	if account.Fingerprint != nil {
+		if account.Fingerprint.matchesEngine(engine) {
+			return account.Fingerprint, nil
+		}
+		log.Infof("[NewPlaywrightScraper] fingerprint of %s was generated for another browser engine, regenerate it for %s", account.Username, engine)
+	}
+	var devices map[string]*playwright.DeviceDescriptor
+	// http 后端没有启动 playwright, 拿不到设备列表, 只生成桌面指纹
+	if len(appContext.Config.FingerprintDevices) > 0 && appContext.Pw != nil {
+		var err error
+		if devices, err = fingerprintDevices(appContext.Pw.Devices, appContext.Config.FingerprintDevices); err != nil {
+			return nil, err
+		}
+	}
+	var version string
+	if appContext.Browsers != nil {
+		var err error
+		if version, err = appContext.Browsers.BrowserVersion(); err != nil {
+			return nil, err
+		}
+	}
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	fingerprint := GenerateFingerprint(random, engine, version, devices)
+	if err := appContext.Accounts.SetAccountFingerprint(account.Username, fingerprint); err != nil {
+		return nil, errors.Wrapf(err, "Can not save fingerprint of %s", account.Username)
+	}
+	log.Infof("[NewPlaywrightScraper] generate fingerprint %v for %s", *fingerprint, account.Username)
+	account.Fingerprint = fingerprint
+	return fingerprint, nil
+}
+
+// saveSession 保存当前的 cookie 和 localStorage, 失败只记录日志
+func (scraper *PlaywrightScraper) saveSession() {
+	if scraper.sessions == nil || scraper.Page == nil {
+		return
+	}
+	state, err := (*scraper.Page).Context().StorageState()
+	if err != nil {
+		log.Errorf("[PlaywrightScraper] Can not read session of %s, %v", scraper.Account.Username, err)
+		return
+	}
+	if err := scraper.sessions.Save(scraper.Account.Username, state); err != nil {
+		log.Errorf("[PlaywrightScraper] Can not save session of %s, %v", scraper.Account.Username, err)
+	}
+}
+
+func (scraper *PlaywrightScraper) FetchProfile(ctx context.Context, url string) (ProfileResult, error) {
+	result, err := scraper.fetchProfile(ctx, url)
+	scraper.proxy.reportError(err)
+	scraper.forgetExpiredSession(err)
+	return result, err
+}
+
+// forgetExpiredSession 抓取时要求登录说明保存的登录状态已经失效, 删掉避免下次再恢复
+func (scraper *PlaywrightScraper) forgetExpiredSession(err error) {
+	if !errors.Is(err, ErrNeedLogin) || !scraper.loggedIn {
+		return
+	}
+	scraper.loggedIn = false
+	if scraper.sessions != nil {
+		if err := scraper.sessions.Delete(scraper.Account.Username); err != nil {
+			log.Errorf("[PlaywrightScraper] Can not delete session of %s, %v", scraper.Account.Username, err)
+		}
+	}
+}
+
+func (scraper *PlaywrightScraper) fetchProfile(ctx context.Context, url string) (ProfileResult, error) {
+	result := newProfileResult()
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	if scraper.config.ParseProfile() {
+		profile, err := GetProfile(scraper.Page, url, scraper.Account.Username)
+		if err != nil {
+			return result, err
+		}
+		result.FansCount = profile.FollowerCount
+		result.FansCountExact = profile.FollowerCountExact
+		result.FollowingCount = profile.FollowingCount
+		result.PostCount = profile.PostCount
+		result.Details = profile.Details
+		result.Private = profile.Private
+	}
+	if scraper.config.ParseStoryLink && !result.Private {
+		stories, err := GetStories(scraper.Page, url, scraper.Account.Username)
+		// 没有解析主页时才会在快拍页面发现是私密账号, 这时没有任何数据, 作为错误返回
+		if errors.Is(err, ErrProfilePrivate) && scraper.config.ParseProfile() {
+			result.Private = true
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Stories = stories
+		result.StoryLink = strings.Join(StoryLinks(stories), ",")
+	}
+	return result, nil
+}
+
+func (scraper *PlaywrightScraper) Relogin(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := LogInToInstagram(scraper.Account, scraper.Page); err != nil {
+		scraper.proxy.reportError(err)
+		return err
+	}
+	scraper.loggedIn = true
+	scraper.saveSession()
+	return nil
+}
+
+// Close 关闭页面和上下文并还给浏览器池, 关闭前保存最新的登录状态
+func (scraper *PlaywrightScraper) Close() {
+	if scraper.loggedIn {
+		scraper.saveSession()
+	}
+	if scraper.Page != nil {
+		if err := (*scraper.Page).Close(); err != nil {
+			log.Errorf("[PlaywrightScraper] Can not close page, %v", err)
+		}
+		scraper.Page = nil
+	}
+	if scraper.Context != nil {
+		scraper.Context.Close()
+		scraper.Context = nil
+	}
+}
@@ -0,0 +1,86 @@
+package instagram_fans
+
+import (
+	"github.com/playwright-community/playwright-go"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestPlaywrightContext(t *testing.T) (*AppContext, *[]*fakeBrowser) {
+	t.Helper()
+	pool, browsers := newFakeBrowserPool(0)
+	t.Cleanup(pool.Close)
+	appContext := &AppContext{
+		Config:   &Config{ParseFansCount: true},
+		Sessions: NewSessionStore(filepath.Join(t.TempDir(), "sessions"), nil),
+		Browsers: pool,
+	}
+	return appContext, browsers
+}
+
+func TestPlaywrightScraperRestoresAndSavesSession(t *testing.T) {
+	appContext, browsers := newTestPlaywrightContext(t)
+	saved := &playwright.StorageState{
+		Cookies: []playwright.Cookie{{Name: "sessionid", Value: "old", Domain: ".instagram.com", Path: "/"}},
+		Origins: []playwright.Origin{},
+	}
+	if err := appContext.Sessions.Save("lun", saved); err != nil {
+		t.Fatalf("save session: %v", err)
+	}
+
+	// 有保存的登录状态时直接恢复, 不会打开登录页(fakePage 没有实现 Goto, 走登录表单会 panic)
+	account := &Account{Username: "lun", Fingerprint: &Fingerprint{Engine: BrowserChromium, Locale: "en-US"}}
+	scraper, err := NewPlaywrightScraper(appContext, account)
+	if err != nil {
+		t.Fatalf("restore session: %v", err)
+	}
+	browserContext := (*browsers)[0].contexts[0]
+	restored := browserContext.options.StorageState
+	if restored == nil || len(restored.Cookies) != 1 || restored.Cookies[0].Value != "old" {
+		t.Fatalf("context should start from the saved session, got %+v", restored)
+	}
+
+	// 关闭时保存最新的登录状态
+	browserContext.state = &playwright.StorageState{
+		Cookies: []playwright.Cookie{{Name: "sessionid", Value: "new", Domain: ".instagram.com", Path: "/"}},
+		Origins: []playwright.Origin{},
+	}
+	scraper.Close()
+	if !browserContext.closed {
+		t.Fatalf("context should be returned to the pool")
+	}
+	loaded, err := appContext.Sessions.Load("lun")
+	if err != nil || !reflect.DeepEqual(loaded, browserContext.state) {
+		t.Fatalf("saved session got %+v, %v, want %+v", loaded, err, browserContext.state)
+	}
+}
+
+func TestPlaywrightScraperForgetsExpiredSession(t *testing.T) {
+	appContext, _ := newTestPlaywrightContext(t)
+	state := &playwright.StorageState{Cookies: []playwright.Cookie{}, Origins: []playwright.Origin{}}
+	if err := appContext.Sessions.Save("lun", state); err != nil {
+		t.Fatalf("save session: %v", err)
+	}
+	scraper, err := NewPlaywrightScraper(appContext, &Account{Username: "lun", Fingerprint: &Fingerprint{Engine: BrowserChromium}})
+	if err != nil {
+		t.Fatalf("restore session: %v", err)
+	}
+	playwrightScraper := scraper.(*PlaywrightScraper)
+
+	playwrightScraper.forgetExpiredSession(ErrPageUnavailable)
+	if loaded, _ := appContext.Sessions.Load("lun"); loaded == nil {
+		t.Fatalf("other errors must keep the session")
+	}
+
+	playwrightScraper.forgetExpiredSession(ErrNeedLogin)
+	if loaded, _ := appContext.Sessions.Load("lun"); loaded != nil || playwrightScraper.loggedIn {
+		t.Fatalf("expired session should be deleted, got %+v", loaded)
+	}
+
+	// 没有重新登录前关闭不会把失效的状态再保存回去
+	scraper.Close()
+	if loaded, _ := appContext.Sessions.Load("lun"); loaded != nil {
+		t.Fatalf("closing a logged out scraper must not save the session")
+	}
+}
@@ -0,0 +1,51 @@
+package instagram_fans
+
+import (
+	"context"
+	"github.com/pkg/errors"
+)
+
+const (
+	ScraperBrowser = "browser"
+	ScraperHttp    = "http"
+)
+
+// ProfileResult 一次抓取博主得到的数据, 数量为 -2 表示没有抓到
+type ProfileResult struct {
+	FansCount      int
+	FansCountExact bool // FansCount 是精确值而不是 K/M 缩写换算出来的
+	FollowingCount int
+	PostCount      int
+	Details        *ProfileDetails // 没有拿到昵称、简介等资料时为 nil
+	Private        bool            // 私密账号, 不会抓快拍
+	StoryLink      string          // 快拍外链去重后用逗号拼接, 与 user.story_link 一致
+	Stories        []StoryItem
+}
+
+func newProfileResult() ProfileResult {
+	return ProfileResult{FansCount: -2, FollowingCount: -2, PostCount: -2}
+}
+
+// Scraper 抓取博主数据的后端, 每个 Scraper 对应一个已经登录的账号。
+// 返回的错误与 GetFansCount 一致(ErrNeedLogin, ErrUserInvalid, ErrPageUnavailable ...), 由调用方决定换号还是重新登录
+type Scraper interface {
+	FetchProfile(ctx context.Context, url string) (ProfileResult, error)
+	// Relogin 遇到 ErrNeedLogin 后用同一个账号重新登录
+	Relogin(ctx context.Context) error
+	Close()
+}
+
+// ScraperFactory 用账号创建并登录一个 Scraper, 登录失败时也可能返回需要关闭的 Scraper
+type ScraperFactory func(appContext *AppContext, account *Account) (Scraper, error)
+
+// NewScraperFactory 按配置里的后端名称返回对应的 ScraperFactory
+func NewScraperFactory(name string) (ScraperFactory, error) {
+	switch name {
+	case ScraperBrowser:
+		return NewPlaywrightScraper, nil
+	case ScraperHttp:
+		return NewHttpScraper, nil
+	default:
+		return nil, errors.Errorf("unknown scraper %s", name)
+	}
+}
@@ -0,0 +1,131 @@
+package instagram_fans
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+)
+
+const (
+	// AccountKeyEnv 账号密码加密密钥(base64 编码的 32 字节), 优先于配置里的 accountKeyFile
+	AccountKeyEnv = "INS_FANS_ACCOUNT_KEY"
+	// NewAccountKeyEnv accounts rekey 使用的新密钥
+	NewAccountKeyEnv = "INS_FANS_NEW_ACCOUNT_KEY"
+
+	encryptedPasswordPrefix = "enc:v1:"
+	maskedSecret            = "******"
+)
+
+var (
+	ErrNoAccountKey       = errors.New("no account key configured")
+	ErrDecryptPassword    = errors.New("can not decrypt account password")
+	ErrInvalidAccountKey  = errors.New("account key must be 32 bytes encoded in base64")
+	errMissingAccountData = errors.New("encrypted password is too short")
+)
+
+// Secret 敏感字符串, 打印时总是被遮蔽
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return maskedSecret
+}
+
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+// PasswordCipher 用 AES-GCM 加密账号密码, 密文格式为 enc:v1:<base64(nonce|ciphertext)>
+type PasswordCipher struct {
+	aead cipher.AEAD
+}
+
+func NewPasswordCipher(key []byte) (*PasswordCipher, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidAccountKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can not create cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can not create gcm")
+	}
+	return &PasswordCipher{aead: aead}, nil
+}
+
+// ParsePasswordKey 解析 base64 编码的密钥
+func ParsePasswordKey(encoded string) (*PasswordCipher, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, ErrInvalidAccountKey
+	}
+	return NewPasswordCipher(key)
+}
+
+// LoadPasswordCipher 依次从环境变量 envName 和密钥文件读取密钥, 都没有配置时返回 ErrNoAccountKey
+func LoadPasswordCipher(envName string, keyFile string) (*PasswordCipher, error) {
+	if encoded := os.Getenv(envName); encoded != "" {
+		return ParsePasswordKey(encoded)
+	}
+	if keyFile == "" {
+		return nil, ErrNoAccountKey
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can not read key file %s", keyFile)
+	}
+	return ParsePasswordKey(string(data))
+}
+
+// GeneratePasswordKey 生成一个新的 base64 编码密钥
+func GeneratePasswordKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func IsEncryptedPassword(stored string) bool {
+	return strings.HasPrefix(stored, encryptedPasswordPrefix)
+}
+
+func (c *PasswordCipher) Encrypt(plain string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return encryptedPasswordPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密数据库里的密码, 没有 enc:v1: 前缀的旧数据按明文返回
+func (c *PasswordCipher) Decrypt(stored string) (string, error) {
+	if !IsEncryptedPassword(stored) {
+		return stored, nil
+	}
+	if c == nil {
+		return "", errors.Wrap(ErrDecryptPassword, ErrNoAccountKey.Error())
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedPasswordPrefix))
+	if err != nil {
+		return "", errors.Wrap(ErrDecryptPassword, err.Error())
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return "", errors.Wrap(ErrDecryptPassword, errMissingAccountData.Error())
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.Wrap(ErrDecryptPassword, err.Error())
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,96 @@
+package instagram_fans
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/playwright-community/playwright-go"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+)
+
+func newTestCipher(t *testing.T) *PasswordCipher {
+	t.Helper()
+	key, err := GeneratePasswordKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	cipher, err := ParsePasswordKey(key)
+	if err != nil {
+		t.Fatalf("parse key: %v", err)
+	}
+	return cipher
+}
+
+func TestPasswordCipherRoundTrip(t *testing.T) {
+	cipher := newTestCipher(t)
+	encrypted, err := cipher.Encrypt("Wl@secret")
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if !IsEncryptedPassword(encrypted) || strings.Contains(encrypted, "Wl@secret") {
+		t.Fatalf("password is not encrypted: %s", encrypted)
+	}
+
+	plain, err := cipher.Decrypt(encrypted)
+	if err != nil || plain != "Wl@secret" {
+		t.Fatalf("decrypt got %q, %v", plain, err)
+	}
+	if _, err := newTestCipher(t).Decrypt(encrypted); err == nil {
+		t.Fatalf("decrypt with another key should fail")
+	}
+
+	legacy, err := cipher.Decrypt("plain-password")
+	if err != nil || legacy != "plain-password" {
+		t.Fatalf("legacy plaintext got %q, %v", legacy, err)
+	}
+}
+
+func TestAccountNeverPrintsPassword(t *testing.T) {
+	account := Account{Username: "lun", Password: "Wl@secret"}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		if out := fmt.Sprintf(format, account); strings.Contains(out, "Wl@secret") {
+			t.Fatalf("%s leaks password: %s", format, out)
+		}
+	}
+}
+
+func TestRekeyAccountPasswords(t *testing.T) {
+	appContext := newTestContext(t)
+	oldCipher := newTestCipher(t)
+	encrypted, _ := oldCipher.Encrypt("encrypted-password")
+	accounts := appContext.Db.Table(appContext.Config.AccountTable).Session(&gorm.Session{})
+	accounts.Create(&Account{Username: "encrypted", Password: Secret(encrypted)})
+	accounts.Create(&Account{Username: "plain", Password: "plain-password"})
+
+	newCipher := newTestCipher(t)
+	count, err := RekeyAccountPasswords(appContext.AccountDb, appContext.Config.AccountTable, oldCipher, newCipher)
+	if err != nil || count != 2 {
+		t.Fatalf("rekey got %d, %v", count, err)
+	}
+
+	want := map[string]string{"encrypted": "encrypted-password", "plain": "plain-password"}
+	var stored []*Account
+	appContext.Db.Table(appContext.Config.AccountTable).Find(&stored)
+	for _, account := range stored {
+		account.cipher = newCipher
+		plain, err := account.RevealPassword()
+		if err != nil || plain != want[account.Username] {
+			t.Fatalf("account %s got %q, %v", account.Username, plain, err)
+		}
+	}
+}
+
+func TestLoginMarksUndecryptablePassword(t *testing.T) {
+	encrypted, err := newTestCipher(t).Encrypt("psw")
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	// 用另一把密钥解不开, 登录不能继续, 账号需要人工检查密钥
+	account := &Account{Username: "lun", Password: Secret(encrypted), cipher: newTestCipher(t)}
+	var page playwright.Page = &fakePage{}
+	err = Login(account, &page)
+	if !errors.Is(err, ErrUserUnusable) || AccountErrorReason(err) != AccountReasonDecryptFailed {
+		t.Fatalf("login got %v, want unusable with decrypt_failed", err)
+	}
+}
@@ -0,0 +1,91 @@
+package instagram_fans
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/playwright-community/playwright-go"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var unsafeFileNamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// SessionStore 把账号登录后的浏览器状态(cookie 和 localStorage)保存在 dir 下, 每个账号一个文件,
+// 下次启动浏览器时恢复, 不用每次都走登录表单。配置了账号密钥时文件内容加密
+type SessionStore struct {
+	dir    string
+	cipher *PasswordCipher
+}
+
+func NewSessionStore(dir string, cipher *PasswordCipher) *SessionStore {
+	return &SessionStore{dir: dir, cipher: cipher}
+}
+
+func (store *SessionStore) path(username string) string {
+	return filepath.Join(store.dir, unsafeFileNamePattern.ReplaceAllString(username, "_")+".json")
+}
+
+// Load 读取账号保存的浏览器状态, 没有保存过时返回 nil
+func (store *SessionStore) Load(username string) (*playwright.StorageState, error) {
+	data, err := os.ReadFile(store.path(username))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can not read session of %s", username)
+	}
+	plain, err := store.cipher.Decrypt(string(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can not decrypt session of %s", username)
+	}
+	var state playwright.StorageState
+	if err := json.Unmarshal([]byte(plain), &state); err != nil {
+		return nil, errors.Wrapf(err, "Can not decode session of %s", username)
+	}
+	return &state, nil
+}
+
+// Save 保存账号的浏览器状态, 文件只有当前用户可读写
+func (store *SessionStore) Save(username string, state *playwright.StorageState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	content := string(data)
+	if store.cipher != nil {
+		if content, err = store.cipher.Encrypt(content); err != nil {
+			return errors.Wrapf(err, "Can not encrypt session of %s", username)
+		}
+	}
+	if err := os.MkdirAll(store.dir, 0700); err != nil {
+		return errors.Wrapf(err, "Can not create session dir %s", store.dir)
+	}
+
+	// 先写临时文件再改名, 避免进程中途退出留下半个文件
+	file, err := os.CreateTemp(store.dir, ".session-*")
+	if err != nil {
+		return errors.Wrapf(err, "Can not save session of %s", username)
+	}
+	defer os.Remove(file.Name())
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		return errors.Wrapf(err, "Can not save session of %s", username)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), store.path(username))
+}
+
+// Delete 删除账号保存的浏览器状态, 没有保存过时不报错
+func (store *SessionStore) Delete(username string) error {
+	if err := os.Remove(store.path(username)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
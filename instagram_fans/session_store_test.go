@@ -0,0 +1,59 @@
+package instagram_fans
+
+import (
+	"github.com/playwright-community/playwright-go"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSessionStoreRoundTrip(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	store := NewSessionStore(dir, newTestCipher(t))
+
+	if state, err := store.Load("lun"); state != nil || err != nil {
+		t.Fatalf("load before save got %v, %v", state, err)
+	}
+
+	state := &playwright.StorageState{
+		Cookies: []playwright.Cookie{{Name: "sessionid", Value: "secret-session", Domain: ".instagram.com", Path: "/", Expires: 1893456000, HttpOnly: true, Secure: true}},
+		Origins: []playwright.Origin{{Origin: "https://www.instagram.com", LocalStorage: []playwright.NameValue{{Name: "ig_key", Value: "1"}}}},
+	}
+	if err := store.Save("lun", state); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "lun.json"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("session file mode got %v, %v, want 0600", info, err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "lun.json"))
+	if strings.Contains(string(data), "secret-session") {
+		t.Fatalf("session file should be encrypted")
+	}
+
+	loaded, err := store.Load("lun")
+	if err != nil || !reflect.DeepEqual(loaded, state) {
+		t.Fatalf("load got %+v, %v, want %+v", loaded, err, state)
+	}
+
+	if err := store.Delete("lun"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if state, err := store.Load("lun"); state != nil || err != nil {
+		t.Fatalf("load after delete got %v, %v", state, err)
+	}
+}
+
+func TestSessionStoreKeepsFileInDir(t *testing.T) {
+	dir := t.TempDir()
+	store := NewSessionStore(dir, nil)
+	if err := store.Save("../evil", &playwright.StorageState{}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".._evil.json")); err != nil {
+		t.Fatalf("session file should stay in %s, %v", dir, err)
+	}
+}
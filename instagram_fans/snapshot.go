@@ -0,0 +1,57 @@
+package instagram_fans
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// BloggerSnapshot 每次成功抓取博主后追加的一条记录, user 表只保留最新值
+type BloggerSnapshot struct {
+	Id             int       `gorm:"primaryKey"`
+	UserId         int       `gorm:"column:user_id;index:idx_snapshot_user_time,priority:1"`
+	FansCount      *int      `gorm:"column:fans_count"`
+	FansCountExact *bool     `gorm:"column:fans_count_exact"`
+	StoryLink      *string   `gorm:"column:story_link;type:text"`
+	Account        string    `gorm:"column:account;type:varchar(128)"`
+	MachineCode    string    `gorm:"column:machine_code;type:varchar(64)"`
+	CreatedAt      time.Time `gorm:"column:created_at;index:idx_snapshot_user_time,priority:2"`
+}
+
+func (BloggerSnapshot) TableName() string {
+	return "blogger_snapshot"
+}
+
+// FindBloggerSnapshots 按时间倒序返回博主最近的 limit 条快照, limit <= 0 时返回全部
+func FindBloggerSnapshots(db *gorm.DB, userId int, limit int) ([]*BloggerSnapshot, error) {
+	var snapshots []*BloggerSnapshot
+	query := db.Where("user_id = ?", userId).Order("created_at DESC, id DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&snapshots).Error; err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// FindBloggerSnapshotsBetween 按时间正序返回博主在 [from, to) 之间的快照, 用于绘制粉丝增长曲线
+func FindBloggerSnapshotsBetween(db *gorm.DB, userId int, from time.Time, to time.Time) ([]*BloggerSnapshot, error) {
+	var snapshots []*BloggerSnapshot
+	err := db.Where("user_id = ?", userId).
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Order("created_at ASC, id ASC").
+		Find(&snapshots).Error
+	if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// FindBloggerSnapshotsByUrl 根据博主主页地址查询快照
+func FindBloggerSnapshotsByUrl(db *gorm.DB, table string, url string, limit int) ([]*BloggerSnapshot, error) {
+	var user User
+	if err := db.Table(table).Where("url = ?", url).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return FindBloggerSnapshots(db, user.Id, limit)
+}
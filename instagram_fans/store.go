@@ -0,0 +1,161 @@
+package instagram_fans
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// BloggerStore 博主表的存储, 运行循环只通过它读写博主数据
+type BloggerStore interface {
+	FindBloger(limit int, machineCode string, lease time.Duration) ([]*User, error)
+	RenewBloggerLease(ids []int, machineCode string, lease time.Duration)
+	MarkUserStatusIdle(ids []int)
+	MarkUserScrapeStatus(user *User, status ScrapeStatus, detail string)
+	RequeueFailedBloggers()
+	UpdateSingleDataToDb(user *User, account string, machineCode string, config *Config)
+	FindBloggerSnapshots(userId int, limit int) ([]*BloggerSnapshot, error)
+	FindStoryLinks(userId int) ([]*BloggerStoryLink, error)
+	FindStoryLinksByDomain(domain string) ([]*BloggerStoryLink, error)
+}
+
+// AccountStore 账号表的存储
+type AccountStore interface {
+	UsableAccountCount() int
+	FindAccount(machineCode string) *Account
+	MarkAccountStatus(account *Account, change AccountStatusChange)
+	MakAccountUsable(machineCode string)
+	SetAccountMachineCode(account *Account, machineCode string)
+	FindAccountHistory(username string, limit int) ([]*AccountAudit, error)
+	SetAccountFingerprint(username string, fingerprint *Fingerprint) error
+}
+
+// ProxyStore 代理表的存储, 与账号表在同一个库里
+type ProxyStore interface {
+	ProxyForAccount(account *Account) (*Proxy, error)
+	MarkProxyFailed(proxy *Proxy, reason error)
+	AddProxy(url string, username string, password string) error
+	SetProxyStatus(url string, status ProxyStatus) error
+	FindProxies() ([]*Proxy, error)
+}
+
+// sqlBloggerStore 基于 gorm 的实现, MySQL 与 SQLite 的差异由 OpenDatabase 返回的连接处理
+type sqlBloggerStore struct {
+	db          *gorm.DB
+	table       string
+	maxAttempts int
+}
+
+func NewBloggerStore(db *gorm.DB, table string, maxAttempts int) BloggerStore {
+	return &sqlBloggerStore{db: db, table: table, maxAttempts: maxAttempts}
+}
+
+func (store *sqlBloggerStore) FindBloger(limit int, machineCode string, lease time.Duration) ([]*User, error) {
+	return FindBloger(store.db, store.table, limit, machineCode, lease)
+}
+
+func (store *sqlBloggerStore) RenewBloggerLease(ids []int, machineCode string, lease time.Duration) {
+	RenewBloggerLease(ids, store.db, store.table, machineCode, lease)
+}
+
+func (store *sqlBloggerStore) MarkUserStatusIdle(ids []int) {
+	MarkUserStatusIdle(ids, store.db, store.table)
+}
+
+func (store *sqlBloggerStore) MarkUserScrapeStatus(user *User, status ScrapeStatus, detail string) {
+	MarkUserScrapeStatus(user, status, detail, store.db, store.table)
+}
+
+func (store *sqlBloggerStore) RequeueFailedBloggers() {
+	RequeueFailedBloggers(store.db, store.table, store.maxAttempts)
+}
+
+func (store *sqlBloggerStore) UpdateSingleDataToDb(user *User, account string, machineCode string, config *Config) {
+	UpdateSingleDataToDb(user, account, machineCode, config, store.db, store.table)
+}
+
+func (store *sqlBloggerStore) FindBloggerSnapshots(userId int, limit int) ([]*BloggerSnapshot, error) {
+	return FindBloggerSnapshots(store.db, userId, limit)
+}
+
+func (store *sqlBloggerStore) FindStoryLinks(userId int) ([]*BloggerStoryLink, error) {
+	return FindStoryLinks(store.db, userId)
+}
+
+func (store *sqlBloggerStore) FindStoryLinksByDomain(domain string) ([]*BloggerStoryLink, error) {
+	return FindStoryLinksByDomain(store.db, store.table, domain)
+}
+
+// sqlAccountStore 查找账号前会先恢复冷却期已过的 unusable 账号, 找到的账号用 cipher 解密密码
+type sqlAccountStore struct {
+	db       *gorm.DB
+	table    string
+	cooldown time.Duration
+	cipher   *PasswordCipher
+}
+
+func NewAccountStore(db *gorm.DB, table string, cooldown time.Duration, cipher *PasswordCipher) AccountStore {
+	return &sqlAccountStore{db: db, table: table, cooldown: cooldown, cipher: cipher}
+}
+
+func (store *sqlAccountStore) UsableAccountCount() int {
+	ReviveCooledDownAccounts(store.db, store.table, store.cooldown)
+	return UsableAccountCount(store.db, store.table)
+}
+
+func (store *sqlAccountStore) FindAccount(machineCode string) *Account {
+	ReviveCooledDownAccounts(store.db, store.table, store.cooldown)
+	return FindAccount(store.db, store.table, machineCode, store.cipher)
+}
+
+func (store *sqlAccountStore) MarkAccountStatus(account *Account, change AccountStatusChange) {
+	MarkAccountStatus(store.db, store.table, account, change)
+}
+
+func (store *sqlAccountStore) MakAccountUsable(machineCode string) {
+	MakAccountUsable(store.db, store.table, machineCode)
+}
+
+func (store *sqlAccountStore) SetAccountMachineCode(account *Account, machineCode string) {
+	SetAccountMachineCode(store.db, store.table, account, machineCode)
+}
+
+func (store *sqlAccountStore) FindAccountHistory(username string, limit int) ([]*AccountAudit, error) {
+	return FindAccountHistory(store.db, username, limit)
+}
+
+func (store *sqlAccountStore) SetAccountFingerprint(username string, fingerprint *Fingerprint) error {
+	return SetAccountFingerprint(store.db, store.table, username, fingerprint)
+}
+
+// sqlProxyStore 分配代理前先用 check 检查代理是否可用, 失败的代理冷却 cooldown 后重新检查
+type sqlProxyStore struct {
+	db           *gorm.DB
+	accountTable string
+	cooldown     time.Duration
+	cipher       *PasswordCipher
+	check        func(*Proxy) error
+}
+
+func NewProxyStore(db *gorm.DB, accountTable string, cooldown time.Duration, cipher *PasswordCipher, check func(*Proxy) error) ProxyStore {
+	return &sqlProxyStore{db: db, accountTable: accountTable, cooldown: cooldown, cipher: cipher, check: check}
+}
+
+func (store *sqlProxyStore) ProxyForAccount(account *Account) (*Proxy, error) {
+	return ProxyForAccount(store.db, store.accountTable, account, store.cipher, store.cooldown, store.check)
+}
+
+func (store *sqlProxyStore) MarkProxyFailed(proxy *Proxy, reason error) {
+	MarkProxyFailed(store.db, proxy, reason)
+}
+
+func (store *sqlProxyStore) AddProxy(url string, username string, password string) error {
+	return AddProxy(store.db, url, username, password, store.cipher)
+}
+
+func (store *sqlProxyStore) SetProxyStatus(url string, status ProxyStatus) error {
+	return SetProxyStatus(store.db, url, status)
+}
+
+func (store *sqlProxyStore) FindProxies() ([]*Proxy, error) {
+	return FindProxies(store.db)
+}
@@ -0,0 +1,232 @@
+package instagram_fans
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StoryMediaType 快拍的媒体类型, 与接口里的 media_type 一致
+type StoryMediaType int
+
+const (
+	StoryMediaImage StoryMediaType = 1
+	StoryMediaVideo StoryMediaType = 2
+)
+
+// StoryItem 一条快拍
+type StoryItem struct {
+	Id         string
+	TakenAt    time.Time
+	ExpiringAt time.Time
+	MediaType  StoryMediaType
+	Links      []StoryLinkSticker
+	Mentions   []string // 被 @ 的用户名
+	Hashtags   []string
+	Locations  []StoryLocation
+}
+
+// StoryLinkSticker 快拍上的外链贴纸, Url 是原始的 l.instagram.com 跳转链接, Link 是解出来的目标地址。
+// 开启 resolveStoryLinks 后 FinalLink 是 Link 经过短链跳转后的最终地址
+type StoryLinkSticker struct {
+	Url           string
+	Link          string
+	DisplayText   string
+	FinalLink     string
+	RedirectChain []string
+}
+
+type StoryLocation struct {
+	Id   string
+	Name string
+}
+
+// jsonId 接口里的 id / pk 有时是字符串有时是数字, 统一按字符串保存
+type jsonId string
+
+func (id *jsonId) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*id = ""
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		*id = jsonId(text)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+	*id = jsonId(number.String())
+	return nil
+}
+
+// storyItemJson 网页版快拍接口(reels_media)里一条快拍的结构, 只保留需要的字段
+type storyItemJson struct {
+	Id                jsonId `json:"id"`
+	Pk                jsonId `json:"pk"` // 没有 id 时用 pk
+	TakenAt           int64  `json:"taken_at"`
+	ExpiringAt        int64  `json:"expiring_at"`
+	MediaType         int    `json:"media_type"`
+	StoryLinkStickers []struct {
+		StoryLink struct {
+			Url        string `json:"url"`
+			DisplayUrl string `json:"display_url"`
+			LinkTitle  string `json:"link_title"`
+		} `json:"story_link"`
+	} `json:"story_link_stickers"`
+	ReelMentions []struct {
+		User struct {
+			Username string `json:"username"`
+		} `json:"user"`
+	} `json:"reel_mentions"`
+	StoryBloksStickers []struct {
+		BloksSticker struct {
+			StickerData struct {
+				IgMention struct {
+					Username string `json:"username"`
+				} `json:"ig_mention"`
+			} `json:"sticker_data"`
+		} `json:"bloks_sticker"`
+	} `json:"story_bloks_stickers"`
+	StoryHashtags []struct {
+		Hashtag struct {
+			Name string `json:"name"`
+		} `json:"hashtag"`
+	} `json:"story_hashtags"`
+	StoryLocations []struct {
+		Location struct {
+			Pk   jsonId `json:"pk"`
+			Name string `json:"name"`
+		} `json:"location"`
+	} `json:"story_locations"`
+}
+
+func (item *storyItemJson) toStoryItem() StoryItem {
+	story := StoryItem{
+		Id:         string(item.Id),
+		TakenAt:    time.Unix(item.TakenAt, 0),
+		ExpiringAt: time.Unix(item.ExpiringAt, 0),
+		MediaType:  StoryMediaType(item.MediaType),
+	}
+	for _, sticker := range item.StoryLinkStickers {
+		if sticker.StoryLink.Url == "" {
+			continue
+		}
+		displayText := sticker.StoryLink.LinkTitle
+		if displayText == "" {
+			displayText = sticker.StoryLink.DisplayUrl
+		}
+		story.Links = append(story.Links, StoryLinkSticker{Url: sticker.StoryLink.Url, Link: parseLink(sticker.StoryLink.Url), DisplayText: displayText})
+	}
+	for _, mention := range item.ReelMentions {
+		story.Mentions = appendUnique(story.Mentions, mention.User.Username)
+	}
+	for _, sticker := range item.StoryBloksStickers {
+		story.Mentions = appendUnique(story.Mentions, sticker.BloksSticker.StickerData.IgMention.Username)
+	}
+	for _, hashtag := range item.StoryHashtags {
+		story.Hashtags = appendUnique(story.Hashtags, hashtag.Hashtag.Name)
+	}
+	for _, location := range item.StoryLocations {
+		id := string(location.Location.Pk)
+		if location.Location.Name == "" && id == "" {
+			continue
+		}
+		story.Locations = append(story.Locations, StoryLocation{Id: id, Name: location.Location.Name})
+	}
+	return story
+}
+
+func appendUnique(values []string, value string) []string {
+	if value == "" || slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+// ParseStoryItems 解析快拍页面里内嵌的 JSON, 返回按 id 去重后的快拍
+func ParseStoryItems(content string) []StoryItem {
+	var items []StoryItem
+	seen := make(map[string]bool)
+	for _, match := range jsonScriptPattern.FindAllStringSubmatch(content, -1) {
+		decoder := json.NewDecoder(strings.NewReader(match[1]))
+		decoder.UseNumber()
+		var payload interface{}
+		if err := decoder.Decode(&payload); err != nil {
+			continue
+		}
+		for _, item := range findStoryItemsInJson(payload) {
+			if seen[item.Id] {
+				continue
+			}
+			seen[item.Id] = true
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// findStoryItemsInJson 递归查找同时带 taken_at 和 expiring_at 的对象
+func findStoryItemsInJson(node interface{}) []StoryItem {
+	var items []StoryItem
+	switch value := node.(type) {
+	case map[string]interface{}:
+		_, hasTakenAt := value["taken_at"]
+		_, hasExpiringAt := value["expiring_at"]
+		if hasTakenAt && hasExpiringAt {
+			if item, ok := decodeStoryItem(value); ok {
+				return append(items, item)
+			}
+		}
+		// 按 key 排序遍历, 保证多次解析得到的顺序一致
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			items = append(items, findStoryItemsInJson(value[key])...)
+		}
+	case []interface{}:
+		for _, child := range value {
+			items = append(items, findStoryItemsInJson(child)...)
+		}
+	}
+	return items
+}
+
+func decodeStoryItem(value map[string]interface{}) (StoryItem, bool) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return StoryItem{}, false
+	}
+	var item storyItemJson
+	if err := json.Unmarshal(data, &item); err != nil {
+		return StoryItem{}, false
+	}
+	if item.Id == "" {
+		item.Id = item.Pk
+	}
+	if item.Id == "" {
+		return StoryItem{}, false
+	}
+	return item.toStoryItem(), true
+}
+
+// StoryLinks 返回所有快拍里去重后的外链目标地址
+func StoryLinks(items []StoryItem) []string {
+	links := make([]string, 0)
+	for _, item := range items {
+		for _, sticker := range item.Links {
+			links = appendUnique(links, sticker.Link)
+		}
+	}
+	return links
+}
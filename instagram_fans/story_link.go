@@ -0,0 +1,193 @@
+package instagram_fans
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// BloggerStoryLink 博主快拍里出现过的外链, 同一个博主的同一个目标地址只保留一行
+type BloggerStoryLink struct {
+	Id           int    `gorm:"primaryKey"`
+	UserId       int    `gorm:"column:user_id"`
+	RawLink      string `gorm:"column:raw_link;type:text"`
+	ResolvedLink string `gorm:"column:resolved_link;type:text"`
+	// LinkHash resolved_link 的 sha256, 与 user_id 组成唯一键, 避免给很长的 url 建索引
+	LinkHash    string    `gorm:"column:link_hash;type:char(64)"`
+	Domain      string    `gorm:"column:domain;type:varchar(255)"`
+	StoryItemId string    `gorm:"column:story_item_id;type:varchar(64)"`
+	FirstSeen   time.Time `gorm:"column:first_seen"`
+	LastSeen    time.Time `gorm:"column:last_seen"`
+	// FinalLink resolved_link 跟随跳转后的最终地址, RedirectChain 是经过的每一跳, 没有解析时为空
+	FinalLink     string     `gorm:"column:final_link;type:text"`
+	FinalDomain   string     `gorm:"column:final_domain;type:varchar(255)"`
+	RedirectChain StringList `gorm:"column:redirect_chain;type:text"`
+	// BloggerUrl 只在按域名查询时从博主表带出
+	BloggerUrl string `gorm:"->;column:url"`
+}
+
+func (BloggerStoryLink) TableName() string {
+	return "story_link"
+}
+
+// newBloggerStoryLink rawLink 是快拍里的原始链接, resolvedLink 是解出来的目标地址
+func newBloggerStoryLink(userId int, rawLink string, resolvedLink string, storyItemId string, seenAt time.Time) *BloggerStoryLink {
+	hash := sha256.Sum256([]byte(resolvedLink))
+	return &BloggerStoryLink{
+		UserId:       userId,
+		RawLink:      rawLink,
+		ResolvedLink: resolvedLink,
+		LinkHash:     hex.EncodeToString(hash[:]),
+		Domain:       LinkDomain(resolvedLink),
+		StoryItemId:  storyItemId,
+		FirstSeen:    seenAt,
+		LastSeen:     seenAt,
+	}
+}
+
+// LinkDomain 链接的域名, 小写并去掉 www.
+func LinkDomain(link string) string {
+	parsedUrl, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(parsedUrl.Hostname()), "www.")
+}
+
+// upsertStoryLinks 写入本次抓到的快拍外链, 已有的链接只更新 last_seen、原始链接和快拍 id,
+// 本次解析出最终地址的链接同时更新跳转结果, 没有解析的保留之前的结果
+func upsertStoryLinks(tx *gorm.DB, links []*BloggerStoryLink) error {
+	var resolved, unresolved []*BloggerStoryLink
+	for _, link := range links {
+		if link.FinalLink != "" {
+			resolved = append(resolved, link)
+		} else {
+			unresolved = append(unresolved, link)
+		}
+	}
+	columns := []string{"raw_link", "story_item_id", "last_seen"}
+	if err := upsertStoryLinksWith(tx, unresolved, columns); err != nil {
+		return err
+	}
+	return upsertStoryLinksWith(tx, resolved, append(columns, "final_link", "final_domain", "redirect_chain"))
+}
+
+func upsertStoryLinksWith(tx *gorm.DB, links []*BloggerStoryLink, columns []string) error {
+	if len(links) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "link_hash"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Create(&links).Error
+}
+
+// storyLinksFromItems 把快拍里的外链贴纸转换成 story_link 记录
+func storyLinksFromItems(userId int, stories []StoryItem, seenAt time.Time) []*BloggerStoryLink {
+	var links []*BloggerStoryLink
+	seen := make(map[string]bool)
+	for _, story := range stories {
+		for _, sticker := range story.Links {
+			if sticker.Link == "" || seen[sticker.Link] {
+				continue
+			}
+			seen[sticker.Link] = true
+			link := newBloggerStoryLink(userId, sticker.Url, sticker.Link, story.Id, seenAt)
+			if sticker.FinalLink != "" {
+				link.FinalLink = sticker.FinalLink
+				link.FinalDomain = LinkDomain(sticker.FinalLink)
+				link.RedirectChain = sticker.RedirectChain
+			}
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// SplitJoinedStoryLinks 拆分旧的逗号拼接的 story_link。url 里本身可能带逗号,
+// 所以只在逗号后面紧跟 http:// 或 https:// 时才拆开
+func SplitJoinedStoryLinks(joined string) []string {
+	var links []string
+	for _, part := range strings.Split(joined, ",") {
+		trimmed := strings.TrimSpace(part)
+		isNewLink := strings.HasPrefix(trimmed, "http://") || strings.HasPrefix(trimmed, "https://")
+		if len(links) == 0 || isNewLink {
+			links = append(links, trimmed)
+			continue
+		}
+		links[len(links)-1] += "," + part
+	}
+	result := links[:0]
+	for _, link := range links {
+		if link != "" {
+			result = append(result, link)
+		}
+	}
+	return result
+}
+
+// FindStoryLinks 返回博主的所有快拍外链, 最近出现的在前
+func FindStoryLinks(db *gorm.DB, userId int) ([]*BloggerStoryLink, error) {
+	var links []*BloggerStoryLink
+	if err := db.Where("user_id = ?", userId).Order("last_seen DESC, id DESC").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// FindStoryLinksByDomain 查询快拍里链接到 domain(包括跳转后的最终域名)的博主, 同时带出博主主页地址
+func FindStoryLinksByDomain(db *gorm.DB, table string, domain string) ([]*BloggerStoryLink, error) {
+	var links []*BloggerStoryLink
+	if !strings.Contains(domain, "://") {
+		domain = "https://" + domain
+	}
+	linkTable := BloggerStoryLink{}.TableName()
+	err := db.Table(linkTable).
+		Select(linkTable+".*, blogger.url").
+		Joins("JOIN "+db.Statement.Quote(table)+" blogger ON blogger.id = "+linkTable+".user_id").
+		Where(linkTable+".domain = ? OR "+linkTable+".final_domain = ?", LinkDomain(domain), LinkDomain(domain)).
+		Order(linkTable + ".last_seen DESC").
+		Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// StringList 以 JSON 数组保存在一个文本列里的字符串列表
+type StringList []string
+
+func (list StringList) Value() (driver.Value, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal([]string(list))
+	return string(data), err
+}
+
+func (list *StringList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*list = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return errors.Errorf("can not scan %T into StringList", value)
+	}
+	if len(data) == 0 {
+		*list = nil
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(list))
+}
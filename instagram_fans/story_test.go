@@ -0,0 +1,89 @@
+package instagram_fans
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseStoryItemsFromFixture(t *testing.T) {
+	items := ParseStoryItems(readFixture(t, "../assets/story_link.txt"))
+	if len(items) != 69 {
+		t.Fatalf("got %d story items, want 69", len(items))
+	}
+
+	var item *StoryItem
+	for i := range items {
+		if items[i].Id == "3377265978508349363_46399670179" {
+			item = &items[i]
+		}
+	}
+	if item == nil {
+		t.Fatalf("story 3377265978508349363_46399670179 not found")
+	}
+	if !item.TakenAt.Equal(time.Unix(1716821312, 0)) || !item.ExpiringAt.Equal(time.Unix(1716907712, 0)) || item.MediaType != StoryMediaVideo {
+		t.Fatalf("unexpected story %+v", item)
+	}
+	if len(item.Links) != 1 || item.Links[0].Link != "https://france777slots.com/?id=67681325" {
+		t.Fatalf("unexpected links %+v", item.Links)
+	}
+
+	// 与原来按行匹配 "story_link" 的结果一致
+	want := []string{"https://france777slots.com/?id=67681325", "https://japanpg.com/?id=90527725", "https://kbt111.com/?id=37950033&type=1&currency=BRL"}
+	got := StoryLinks(items)
+	if len(got) != len(want) {
+		t.Fatalf("got links %v, want %v", got, want)
+	}
+	for _, link := range want {
+		if !slices.Contains(got, link) {
+			t.Fatalf("link %s missing from %v", link, got)
+		}
+	}
+}
+
+func TestParseStoryItemsStickers(t *testing.T) {
+	content := `<script type="application/json">{"reels_media":[{"items":[{"id":"1_2","taken_at":1716809291,"expiring_at":1716895691,"media_type":1,
+		"story_link_stickers":[{"story_link":{"url":"https://l.instagram.com/?u=https%3A%2F%2Fshop.example.com%2Fsale&e=AT0","display_url":"shop.example.com/sale","link_title":"Sale"}}],
+		"reel_mentions":[{"user":{"username":"friend"}}],
+		"story_bloks_stickers":[{"bloks_sticker":{"sticker_data":{"ig_mention":{"username":"partner"}}}},{"bloks_sticker":{"sticker_data":{"ig_mention":{"username":"friend"}}}}],
+		"story_hashtags":[{"hashtag":{"name":"summer"}}],
+		"story_locations":[{"location":{"pk":213385402,"name":"São Paulo"}}]}]}]}</script>`
+
+	items := ParseStoryItems(content)
+	if len(items) != 1 {
+		t.Fatalf("got %d items, want 1", len(items))
+	}
+	want := StoryItem{
+		Id:         "1_2",
+		TakenAt:    time.Unix(1716809291, 0),
+		ExpiringAt: time.Unix(1716895691, 0),
+		MediaType:  StoryMediaImage,
+		Links:      []StoryLinkSticker{{Url: "https://l.instagram.com/?u=https%3A%2F%2Fshop.example.com%2Fsale&e=AT0", Link: "https://shop.example.com/sale", DisplayText: "Sale"}},
+		Mentions:   []string{"friend", "partner"},
+		Hashtags:   []string{"summer"},
+		Locations:  []StoryLocation{{Id: "213385402", Name: "São Paulo"}},
+	}
+	if !reflect.DeepEqual(items[0], want) {
+		t.Fatalf("got %+v, want %+v", items[0], want)
+	}
+}
+
+func TestParseStoryItemsWithNumericIds(t *testing.T) {
+	// 部分接口返回的 id / pk 是数字, 也有只带 pk 的快拍
+	items := ParseStoryItems(readFixture(t, "testdata/story_numeric_id.html"))
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.Id
+	}
+	want := []string{"3377265978508349363", "3377265978508349364", "3377265978508349365_46399670179"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("got ids %v, want %v", ids, want)
+	}
+	if len(items[0].Links) != 1 || items[0].Links[0].Link != "https://shop.example.com/numeric" {
+		t.Fatalf("unexpected links %+v", items[0].Links)
+	}
+	if len(items[1].Locations) != 1 || items[1].Locations[0].Id != "213385402" {
+		t.Fatalf("unexpected locations %+v", items[1].Locations)
+	}
+}
@@ -1,8 +1,67 @@
 package instagram_fans
 
+import (
+	"github.com/pkg/errors"
+	"time"
+)
+
+// ScrapeStatus 博主的抓取状态, 与 fans_count 分开存储, 避免真实粉丝数与队列状态混淆
+type ScrapeStatus string
+
+const (
+	ScrapeStatusPending     ScrapeStatus = "pending"     // 等待抓取
+	ScrapeStatusClaimed     ScrapeStatus = "claimed"     // 已被某个 worker 领取
+	ScrapeStatusDone        ScrapeStatus = "done"        // 抓取成功
+	ScrapeStatusFailed      ScrapeStatus = "failed"      // 抓取失败, 可以重新排队
+	ScrapeStatusUnavailable ScrapeStatus = "unavailable" // 主页暂时打不开, 可以重新排队
+	ScrapeStatusPrivate     ScrapeStatus = "private"     // 私密账号
+	ScrapeStatusNotFound    ScrapeStatus = "not_found"   // 主页不存在, 已改名或被封
+	ScrapeStatusRestricted  ScrapeStatus = "restricted"  // 有年龄或地区限制, 当前账号看不到
+)
+
+// ScrapeStatusForError 抓取出错后博主应该进入的状态, 只有 failed 和 unavailable 会被重新排队
+func ScrapeStatusForError(err error) ScrapeStatus {
+	switch {
+	case errors.Is(err, ErrProfileNotFound):
+		return ScrapeStatusNotFound
+	case errors.Is(err, ErrProfilePrivate):
+		return ScrapeStatusPrivate
+	case errors.Is(err, ErrProfileRestricted):
+		return ScrapeStatusRestricted
+	case errors.Is(err, ErrPageUnavailable):
+		return ScrapeStatusUnavailable
+	}
+	return ScrapeStatusFailed
+}
+
 type User struct {
 	Id        int    `gorm:"primaryKey"`
 	Url       string `gorm:"unique"`
 	StoryLink string `gorm:"default:null"`
 	FansCount int    `gorm:"default:-1"`
+	// FansCountExact fans_count 是精确值, 为 false 时是 "12.3K" 这类缩写换算出的近似值
+	FansCountExact bool `gorm:"column:fans_count_exact;default:false"`
+	// 以下资料由 parseFollowingCount 等配置开启后写入, 数量为 -1 表示还没有抓过
+	FollowingCount int          `gorm:"column:following_count;default:-1"`
+	PostCount      int          `gorm:"column:post_count;default:-1"`
+	FullName       string       `gorm:"column:full_name;type:varchar(255);default:null"`
+	Biography      string       `gorm:"column:biography;type:text;default:null"`
+	ExternalUrl    string       `gorm:"column:external_url;type:text;default:null"`
+	IsVerified     bool         `gorm:"column:is_verified;default:false"`
+	Category       string       `gorm:"column:category;type:varchar(255);default:null"`
+	IsPrivate      bool         `gorm:"column:is_private;default:false"`
+	ScrapeStatus   ScrapeStatus `gorm:"column:scrape_status;type:varchar(16);default:pending;index"`
+	// ScrapeDetail 最近一次没有抓到数据的原因, 比如 "profile not found", 抓取成功后清空
+	ScrapeDetail string `gorm:"column:scrape_detail;type:varchar(255);default:null"`
+	// ScrapeAttempts 连续抓取失败或打不开的次数, 抓取成功后清零
+	ScrapeAttempts int `gorm:"column:scrape_attempts;default:0"`
+	// ClaimedBy 领取该博主的机器码, LeaseExpiresAt 之后其他机器可以重新领取
+	ClaimedBy      string     `gorm:"column:claimed_by;type:varchar(64);default:null"`
+	LeaseExpiresAt *time.Time `gorm:"column:lease_expires_at;default:null"`
+	// Private 本次抓取发现是私密账号, 与 IsPrivate 列不同, 不受 parsePrivate 配置影响
+	Private bool `gorm:"-"`
+	// Details 本次抓到的昵称、简介等资料, 为 nil 时不更新这些列
+	Details *ProfileDetails `gorm:"-"`
+	// Stories 本次抓到的快拍, UpdateSingleDataToDb 把其中的外链写入 story_link 表
+	Stories []StoryItem `gorm:"-"`
 }
@@ -1,13 +1,13 @@
 package main
 
 import (
+	"context"
 	"github.com/charmbracelet/log"
 	"github.com/emirpasic/gods/sets/treeset"
 	"github.com/petermattis/goid"
 	"github.com/pkg/errors"
-	"github.com/playwright-community/playwright-go"
-	"gorm.io/gorm"
 	"instgram_fans/instagram_fans"
+	"os"
 	"sync"
 	"time"
 
@@ -20,31 +20,36 @@ var (
 	StatusNext               = 3
 )
 
+// maxReloginsPerBlogger 同一个博主重新登录后仍然要求登录的次数上限, 超过后只把博主记为失败,
+// 账号的重新登录都成功了, 不改账号状态, 继续抓下一个博主
+const maxReloginsPerBlogger = 2
+
 type PageContext struct {
-	Browser *playwright.Browser
-	Page    *playwright.Page
+	Scraper instagram_fans.Scraper
 	Account *instagram_fans.Account
 	goId    int64
 }
 
 func (p *PageContext) Close() {
-	if p.Page != nil {
-		err := (*p.Page).Close()
-		if err != nil {
-			return
-		}
+	if p.Scraper != nil {
+		p.Scraper.Close()
+		p.Scraper = nil
 	}
-	if p.Browser != nil {
-		err := (*p.Browser).Close()
-		if err != nil {
-			return
-		}
+	if p.Account != nil {
+		log.Infof("[%d] Context is closed for account[%s]", p.goId, p.Account.Username)
 	}
-	log.Infof("[%d] Context is closed for account[%s]", p.goId, p.Account.Username)
 	p.Account = nil
 }
 
 func main() {
+	instagram_fans.InstallRedactLogger()
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatalf("Command %v failed, %v", os.Args[1:], err)
+		}
+		return
+	}
+
 	log.Info("start")
 
 	appContext, err := instagram_fans.InitContext()
@@ -54,7 +59,8 @@ func main() {
 	}
 	defer appContext.DestroyContext()
 
-	instagram_fans.MakAccountUsable(appContext.AccountDb, appContext.Config.AccountTable, appContext.MachineCode)
+	appContext.Accounts.MakAccountUsable(appContext.MachineCode)
+	appContext.Bloggers.RequeueFailedBloggers()
 	// 计算可以使用的账号
 	finalAccountCount := computeAccountCount(appContext)
 	if finalAccountCount == 0 {
@@ -71,12 +77,13 @@ func main() {
 func updateData(appContext *instagram_fans.AppContext, count int) error {
 	var wg sync.WaitGroup
 	var markAccountMutex sync.Mutex
+	ctx := context.Background()
 
 	for i := 0; i < count; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := UpdateUserInfo(appContext, &markAccountMutex)
+			err := UpdateUserInfo(ctx, appContext, &markAccountMutex)
 			if err != nil {
 				log.Errorf("Update user info failed %v\n", err)
 				return
@@ -87,10 +94,8 @@ func updateData(appContext *instagram_fans.AppContext, count int) error {
 	return nil
 }
 
-func UpdateUserInfo(appContext *instagram_fans.AppContext, mutex *sync.Mutex) error {
+func UpdateUserInfo(ctx context.Context, appContext *instagram_fans.AppContext, mutex *sync.Mutex) error {
 
-	low := 0
-	db := appContext.Db
 	config := appContext.Config
 
 	var pageContext *PageContext
@@ -98,28 +103,28 @@ func UpdateUserInfo(appContext *instagram_fans.AppContext, mutex *sync.Mutex) er
 	count := 0
 
 	for {
-		mutex.Lock()
-		users, err := fetchBloggerToHandle(db, config, low)
+		users, err := fetchBloggerToHandle(appContext)
 		if err != nil {
 			log.Errorf("Can not find user empty data, %v", err)
-			mutex.Unlock()
 			return errors.Wrap(err, "Can not find user empty data")
 		}
-		mutex.Unlock()
 		if len(users) == 0 {
 			log.Infof("Done for this browser, no data to handle")
 			break
 		}
 
 		log.Infof("find %d users for (%d ~ %d)!!!", len(users), users[0].Id, users[len(users)-1].Id)
-		low = users[len(users)-1].Id
 
 		set.Clear()
+		ids := make([]int, 0, len(users))
 		for _, user := range users {
 			set.Add(user.Id)
+			ids = append(ids, user.Id)
 		}
+		stopRenew := renewLeases(appContext, ids)
 
 		for _, user := range users {
+			relogins := 0
 		ChooseAccountAndLogin:
 			// 创建pageContext，找到一个可用的账号并登录成功
 			log.Infof("[UpdateUserInfo] ChooseAccountAndLogin, context(%v)", pageContext)
@@ -128,38 +133,52 @@ func UpdateUserInfo(appContext *instagram_fans.AppContext, mutex *sync.Mutex) er
 				if err != nil {
 					log.Errorf("Can not init page context, %v", err)
 					if !set.Empty() {
-						values := set.Values()
-						begin := values[0].(int)
-						end := values[len(values)-1].(int)
-						log.Errorf("Some users are not handled(%d ~ %d)", begin, end)
-						instagram_fans.MarkUserStatusIdle(begin, end, db, config.Table)
+						unhandled := make([]int, 0, set.Size())
+						for _, value := range set.Values() {
+							unhandled = append(unhandled, value.(int))
+						}
+						log.Errorf("Some users are not handled(%v)", unhandled)
+						appContext.Bloggers.MarkUserStatusIdle(unhandled)
 					}
 					pageContext = nil
+					close(stopRenew)
 					return err
 				}
 				log.Infof("start to fetch data using account %s (%d - %d)", pageContext.Account.Username, users[0].Id, users[len(users)-1].Id)
 			}
 
 		FetchData:
-			fetchErr := fetchBloggerData(appContext, pageContext, user)
+			fetchErr := fetchBloggerData(ctx, pageContext, user)
 
 			if fetchErr != nil {
-				status := handleFetchErr(fetchErr, appContext, pageContext)
+				if errors.Is(fetchErr, instagram_fans.ErrNeedLogin) && relogins >= maxReloginsPerBlogger {
+					log.Errorf("[UpdateUserInfo] %s still needs login after %d relogins, give up", user.Url, relogins)
+					set.Remove(user.Id)
+					appContext.Bloggers.MarkUserScrapeStatus(user, instagram_fans.ScrapeStatusFailed, fetchErr.Error())
+					continue
+				}
+				status := handleFetchErr(ctx, fetchErr, appContext, pageContext, user)
 				if status == StatusNeedAnotherAccount {
 					pageContext = nil
 					goto ChooseAccountAndLogin
 				} else if status == StatusCanRefetch {
+					relogins++
 					time.Sleep(time.Duration(appContext.Config.DelayConfig.DelayAfterLogin) * time.Second)
 					goto FetchData
 				} else if status == StatusNext {
+					set.Remove(user.Id)
+					appContext.Bloggers.MarkUserScrapeStatus(user, instagram_fans.ScrapeStatusForError(fetchErr), fetchErr.Error())
 					time.Sleep(time.Duration(appContext.Config.DelayConfig.DelayForNext) * time.Second)
 					continue
 				}
 			}
 
 			set.Remove(user.Id)
+			if appContext.LinkResolver != nil {
+				appContext.LinkResolver.ResolveStories(ctx, user.Stories)
+			}
 			log.Infof("[%d] fans_count: %d, story_link: %s for %s", pageContext.goId, user.FansCount, user.StoryLink, user.Url)
-			instagram_fans.UpdateSingleDataToDb(user, appContext)
+			appContext.Bloggers.UpdateSingleDataToDb(user, pageContext.Account.Username, appContext.MachineCode, config)
 
 			time.Sleep(time.Duration(appContext.Config.DelayConfig.DelayForNext) * time.Millisecond)
 
@@ -173,11 +192,12 @@ func UpdateUserInfo(appContext *instagram_fans.AppContext, mutex *sync.Mutex) er
 				goto ChooseAccountAndLogin
 			}
 		}
+		close(stopRenew)
 	}
 
 	if pageContext != nil {
 		if pageContext.Account != nil {
-			instagram_fans.MarkAccountStatus(appContext.AccountDb, appContext.Config.AccountTable, pageContext.Account, 0, appContext.MachineCode)
+			appContext.Accounts.MarkAccountStatus(pageContext.Account, instagram_fans.AccountStatusChange{Status: instagram_fans.AccountStatusIdle, Reason: instagram_fans.AccountReasonReleased, MachineCode: appContext.MachineCode})
 		}
 		pageContext.Close()
 	}
@@ -185,8 +205,9 @@ func UpdateUserInfo(appContext *instagram_fans.AppContext, mutex *sync.Mutex) er
 	return nil
 }
 
-func fetchBloggerToHandle(db *gorm.DB, config *instagram_fans.Config, low int) ([]*instagram_fans.User, error) {
-	users, err := instagram_fans.FindBloger(db, config.Table, config.Count, low)
+func fetchBloggerToHandle(appContext *instagram_fans.AppContext) ([]*instagram_fans.User, error) {
+	config := appContext.Config
+	users, err := appContext.Bloggers.FindBloger(config.Count, appContext.MachineCode, config.LeaseDuration())
 	if err != nil {
 		log.Errorf("Can not find user empty data, %v", err)
 		return nil, errors.Wrap(err, "Can not find user empty data")
@@ -194,55 +215,79 @@ func fetchBloggerToHandle(db *gorm.DB, config *instagram_fans.Config, low int) (
 
 	if len(users) == 0 {
 		log.Infof("Done ALL! no data need to handle")
-		return users, nil
 	}
-	instagram_fans.MarkUserStatusIsWorking(users, db, config.Table)
 	return users, nil
 }
 
-func fetchBloggerData(appContext *instagram_fans.AppContext, pageContext *PageContext, user *instagram_fans.User) error {
-	user.FansCount = -2
-
-	if appContext.Config.ParseFansCount {
-		fansCount, err := instagram_fans.GetFansCount(pageContext.Page, user.Url, pageContext.Account.Username)
-		if err != nil {
-			return err
+// renewLeases 在抓取期间定时续租, 关闭返回的 channel 后停止
+func renewLeases(appContext *instagram_fans.AppContext, ids []int) chan struct{} {
+	stop := make(chan struct{})
+	lease := appContext.Config.LeaseDuration()
+	go func() {
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				appContext.Bloggers.RenewBloggerLease(ids, appContext.MachineCode, lease)
+			}
 		}
+	}()
+	return stop
+}
 
-		user.FansCount = fansCount
-	}
-	if appContext.Config.ParseStoryLink {
-		storyLink, err := instagram_fans.GetStoriesLink(pageContext.Page, user.Url, pageContext.Account.Username)
+func fetchBloggerData(ctx context.Context, pageContext *PageContext, user *instagram_fans.User) error {
+	user.FansCount = -2
 
-		if err != nil {
-			return err
-		}
-		user.StoryLink = storyLink
+	profile, err := pageContext.Scraper.FetchProfile(ctx, user.Url)
+	if err != nil {
+		return err
 	}
+	user.FansCount = profile.FansCount
+	user.FansCountExact = profile.FansCountExact
+	user.FollowingCount = profile.FollowingCount
+	user.PostCount = profile.PostCount
+	user.Details = profile.Details
+	user.Private = profile.Private
+	user.StoryLink = profile.StoryLink
+	user.Stories = profile.Stories
 	return nil
 }
 
 func initPageContext(appContext *instagram_fans.AppContext, mutex *sync.Mutex) (*PageContext, error) {
 	for {
 		mutex.Lock()
-		account := instagram_fans.FindAccount(appContext.AccountDb, appContext.Config.AccountTable, appContext.MachineCode)
+		account := appContext.Accounts.FindAccount(appContext.MachineCode)
 		if account != nil {
-			instagram_fans.MarkAccountStatus(appContext.AccountDb, appContext.Config.AccountTable, account, 1, appContext.MachineCode)
+			appContext.Accounts.MarkAccountStatus(account, instagram_fans.AccountStatusChange{Status: instagram_fans.AccountStatusInUse, Reason: instagram_fans.AccountReasonNone, MachineCode: appContext.MachineCode})
 		}
 		mutex.Unlock()
 
 		if account == nil {
-			instagram_fans.MakAccountUsable(appContext.AccountDb, appContext.Config.AccountTable, appContext.MachineCode)
+			appContext.Accounts.MakAccountUsable(appContext.MachineCode)
 			return nil, errors.New("No account available!!")
 		}
 
 		pageContext, err := getLoginPageContext(appContext, account)
 		if err != nil {
 			log.Errorf("Can not get login in mark user(%s): error(%v) ", account.Username, err)
-			if errors.Is(err, instagram_fans.ErrUserUnusable) {
-				instagram_fans.MarkAccountStatus(appContext.AccountDb, appContext.Config.AccountTable, account, -2, appContext.MachineCode)
+			if errors.Is(err, instagram_fans.ErrNoHealthyProxy) {
+				// 没有可用的代理时换账号也没用, 释放账号后退出
+				appContext.Accounts.MarkAccountStatus(account, instagram_fans.AccountStatusChange{Status: instagram_fans.AccountStatusIdle, Reason: instagram_fans.AccountReasonProxyFailed, MachineCode: appContext.MachineCode})
+				if pageContext != nil {
+					pageContext.Close()
+				}
+				return nil, err
+			}
+			if errors.Is(err, instagram_fans.ErrProxyFailed) {
+				// 代理的问题, 账号本身没问题, 释放后下次换一个代理
+				appContext.Accounts.MarkAccountStatus(account, instagram_fans.AccountStatusChange{Status: instagram_fans.AccountStatusIdle, Reason: instagram_fans.AccountReasonProxyFailed, MachineCode: appContext.MachineCode})
+			} else if errors.Is(err, instagram_fans.ErrUserUnusable) {
+				appContext.Accounts.MarkAccountStatus(account, instagram_fans.AccountStatusChange{Status: instagram_fans.AccountStatusUnusable, Reason: instagram_fans.AccountErrorReason(err), MachineCode: appContext.MachineCode})
 			} else if errors.Is(err, instagram_fans.ErrUserInvalid) {
-				instagram_fans.MarkAccountStatus(appContext.AccountDb, appContext.Config.AccountTable, account, -1, appContext.MachineCode)
+				appContext.Accounts.MarkAccountStatus(account, instagram_fans.AccountStatusChange{Status: instagram_fans.AccountStatusInvalid, Reason: instagram_fans.AccountErrorReason(err), MachineCode: appContext.MachineCode})
 			}
 
 			if pageContext != nil {
@@ -261,21 +306,9 @@ func getLoginPageContext(appContext *instagram_fans.AppContext, account *instagr
 	pageContext.goId = goid.Get()
 	pageContext.Account = account
 
-	browser, err := instagram_fans.NewBrowser(appContext.Pw)
-	if err != nil {
-		return &pageContext, errors.Wrap(err, "Can not create browser!!!")
-	}
-	pageContext.Browser = browser
-
-	page, err := instagram_fans.NewPage(browser)
+	scraper, err := appContext.NewScraper(appContext, account)
+	pageContext.Scraper = scraper
 	if err != nil {
-		return &pageContext, errors.Wrap(err, "Can not create page!!!")
-	}
-	pageContext.Page = page
-
-	log.Infof("using account: %v", *account)
-
-	if err := instagram_fans.LogInToInstagram(account, page); err != nil {
 		log.Errorf("[%d] getLoginPage Can not login to instagram!!! %v", pageContext.goId, err)
 		return &pageContext, err
 	}
@@ -284,7 +317,7 @@ func getLoginPageContext(appContext *instagram_fans.AppContext, account *instagr
 }
 
 func computeAccountCount(appContext *instagram_fans.AppContext) int {
-	usableAccountCount := instagram_fans.UsableAccountCount(appContext.AccountDb, appContext.Config.AccountTable)
+	usableAccountCount := appContext.Accounts.UsableAccountCount()
 	if usableAccountCount == 0 {
 		log.Errorf("No Count Avaliable found in account table")
 		return 0
@@ -294,13 +327,21 @@ func computeAccountCount(appContext *instagram_fans.AppContext) int {
 	return finalAccountCount
 }
 
-func handleFetchErr(fetchErr error, appContext *instagram_fans.AppContext, pageContext *PageContext) int {
+func handleFetchErr(ctx context.Context, fetchErr error, appContext *instagram_fans.AppContext, pageContext *PageContext, user *instagram_fans.User) int {
+	if errors.Is(fetchErr, instagram_fans.ErrProxyFailed) {
+		log.Errorf("[handleFetchErr] [%d] proxy of account [%v] failed: %v, choose account again", pageContext.goId, pageContext.Account, fetchErr)
+		appContext.Accounts.MarkAccountStatus(pageContext.Account, instagram_fans.AccountStatusChange{Status: instagram_fans.AccountStatusIdle, Reason: instagram_fans.AccountReasonProxyFailed, MachineCode: appContext.MachineCode, BloggerUrl: user.Url})
+		pageContext.Close()
+		pageContext = nil
+		return StatusNeedAnotherAccount
+	}
+
 	if errors.Is(fetchErr, instagram_fans.ErrUserInvalid) || errors.Is(fetchErr, instagram_fans.ErrUserUnusable) {
 		log.Errorf("[handleFetchErr] [%d] enconter err need ChooseAccountAndLogin: %v, ChooseAccountAndLogin again, account [%v]", pageContext.goId, fetchErr, pageContext.Account)
 		if errors.Is(fetchErr, instagram_fans.ErrUserUnusable) {
-			instagram_fans.MarkAccountStatus(appContext.AccountDb, appContext.Config.AccountTable, pageContext.Account, -2, appContext.MachineCode)
+			appContext.Accounts.MarkAccountStatus(pageContext.Account, instagram_fans.AccountStatusChange{Status: instagram_fans.AccountStatusUnusable, Reason: instagram_fans.AccountErrorReason(fetchErr), MachineCode: appContext.MachineCode, BloggerUrl: user.Url})
 		} else {
-			instagram_fans.MarkAccountStatus(appContext.AccountDb, appContext.Config.AccountTable, pageContext.Account, -1, appContext.MachineCode)
+			appContext.Accounts.MarkAccountStatus(pageContext.Account, instagram_fans.AccountStatusChange{Status: instagram_fans.AccountStatusInvalid, Reason: instagram_fans.AccountErrorReason(fetchErr), MachineCode: appContext.MachineCode, BloggerUrl: user.Url})
 		}
 		pageContext.Close()
 		pageContext = nil
@@ -309,7 +350,10 @@ func handleFetchErr(fetchErr error, appContext *instagram_fans.AppContext, pageC
 
 	if errors.Is(fetchErr, instagram_fans.ErrNeedLogin) {
 		log.Errorf("[handleFetchErr] %s enconter err need relogin: %v, relogin again", pageContext.Account.Username, fetchErr)
-		if err := instagram_fans.LogInToInstagram(pageContext.Account, pageContext.Page); err != nil {
+		if err := pageContext.Scraper.Relogin(ctx); err != nil {
+			if errors.Is(err, instagram_fans.ErrUserInvalid) || errors.Is(err, instagram_fans.ErrUserUnusable) || errors.Is(err, instagram_fans.ErrProxyFailed) {
+				return handleFetchErr(ctx, err, appContext, pageContext, user)
+			}
 			pageContext.Close()
 			pageContext = nil
 			return StatusNeedAnotherAccount
@@ -322,5 +366,11 @@ func handleFetchErr(fetchErr error, appContext *instagram_fans.AppContext, pageC
 		log.Errorf("[handleFetchErr] page unavailable %v", fetchErr)
 		return StatusNext
 	}
+	// 不存在、私密、受限是博主主页本身的状态, 其他错误才是抓取失败
+	if status := instagram_fans.ScrapeStatusForError(fetchErr); status != instagram_fans.ScrapeStatusFailed {
+		log.Warnf("[handleFetchErr] %s is %s, %v", user.Url, status, fetchErr)
+	} else {
+		log.Errorf("[handleFetchErr] fetch %s failed, %v", user.Url, fetchErr)
+	}
 	return StatusNext
 }
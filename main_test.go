@@ -0,0 +1,174 @@
+package main
+
+import (
+	"gorm.io/gorm"
+	"instgram_fans/instagram_fans"
+	"path/filepath"
+	"testing"
+)
+
+func newFakeAppContext(t *testing.T, factory instagram_fans.ScraperFactory) *instagram_fans.AppContext {
+	t.Helper()
+	db, err := instagram_fans.OpenDatabase(instagram_fans.DriverSQLite, filepath.Join(t.TempDir(), "instagram.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { instagram_fans.SafeCloseDB(db) })
+
+	config := &instagram_fans.Config{
+		AccountCount:   1,
+		Table:          "user",
+		AccountTable:   "users",
+		Count:          2,
+		MaxCount:       100,
+		ParseFansCount: true,
+		ParseStoryLink: true,
+	}
+	appContext := &instagram_fans.AppContext{
+		Db:          db,
+		AccountDb:   db,
+		Bloggers:    instagram_fans.NewBloggerStore(db, config.Table, config.ScrapeAttemptLimit()),
+		Accounts:    instagram_fans.NewAccountStore(db, config.AccountTable, config.AccountCooldown(), nil),
+		Config:      config,
+		MachineCode: "machine-test",
+		NewScraper:  factory,
+	}
+	if err := instagram_fans.MigrateUp(appContext); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return appContext
+}
+
+func TestUpdateDataWithFakeScraper(t *testing.T) {
+	const (
+		okUrl          = "https://www.instagram.com/ok"
+		reloginUrl     = "https://www.instagram.com/relogin"
+		unavailableUrl = "https://www.instagram.com/unavailable"
+		notFoundUrl    = "https://www.instagram.com/renamed"
+		privateUrl     = "https://www.instagram.com/private"
+	)
+	var scrapers []*fakeScraper
+	factory := func(appContext *instagram_fans.AppContext, account *instagram_fans.Account) (instagram_fans.Scraper, error) {
+		if account.Username == "confirm" {
+			return nil, &instagram_fans.AccountError{Err: instagram_fans.ErrUserUnusable, Reason: instagram_fans.AccountReasonHelpConfirm}
+		}
+		scraper := &fakeScraper{
+			Account: account,
+			Profiles: map[string]instagram_fans.ProfileResult{
+				okUrl:      {FansCount: 100, StoryLink: "https://example.com/a"},
+				reloginUrl: {FansCount: 200},
+				privateUrl: {FansCount: 50, Private: true},
+			},
+			Errors: map[string]error{reloginUrl: instagram_fans.ErrNeedLogin, notFoundUrl: instagram_fans.ErrProfileNotFound},
+		}
+		scrapers = append(scrapers, scraper)
+		return scraper, nil
+	}
+	appContext := newFakeAppContext(t, factory)
+
+	// confirm 账号绑定本机, FindAccount 会优先选它
+	accountTable := appContext.Db.Table(appContext.Config.AccountTable).Session(&gorm.Session{})
+	accountTable.Create(&instagram_fans.Account{Username: "confirm", Password: "psw", MachineCode: appContext.MachineCode})
+	accountTable.Create(&instagram_fans.Account{Username: "good", Password: "psw"})
+	for _, url := range []string{okUrl, reloginUrl, unavailableUrl, notFoundUrl, privateUrl} {
+		appContext.Db.Table(appContext.Config.Table).Create(&instagram_fans.User{Url: url})
+	}
+
+	if err := updateData(appContext, 1); err != nil {
+		t.Fatalf("update data: %v", err)
+	}
+
+	want := map[string]struct {
+		status    instagram_fans.ScrapeStatus
+		fansCount int
+		detail    string
+	}{
+		okUrl:          {instagram_fans.ScrapeStatusDone, 100, ""},
+		reloginUrl:     {instagram_fans.ScrapeStatusDone, 200, ""},
+		unavailableUrl: {instagram_fans.ScrapeStatusUnavailable, -1, "page is unavailable"},
+		notFoundUrl:    {instagram_fans.ScrapeStatusNotFound, -1, "profile not found"},
+		privateUrl:     {instagram_fans.ScrapeStatusPrivate, 50, "profile is private"},
+	}
+	var users []*instagram_fans.User
+	appContext.Db.Table(appContext.Config.Table).Find(&users)
+	for _, user := range users {
+		got := want[user.Url]
+		if user.ScrapeStatus != got.status || user.FansCount != got.fansCount || user.ScrapeDetail != got.detail {
+			t.Errorf("%s got (%s, %d, %q), want %+v", user.Url, user.ScrapeStatus, user.FansCount, user.ScrapeDetail, got)
+		}
+	}
+
+	// 只有暂时打不开的主页会被重新排队
+	appContext.Bloggers.RequeueFailedBloggers()
+	var pending []string
+	appContext.Db.Table(appContext.Config.Table).Where("scrape_status = ?", instagram_fans.ScrapeStatusPending).Pluck("url", &pending)
+	if len(pending) != 1 || pending[0] != unavailableUrl {
+		t.Errorf("requeued %v, want only %s", pending, unavailableUrl)
+	}
+
+	var accounts []*instagram_fans.Account
+	appContext.Db.Table(appContext.Config.AccountTable).Find(&accounts)
+	for _, account := range accounts {
+		switch account.Username {
+		case "confirm":
+			if account.Status != instagram_fans.AccountStatusUnusable || account.StatusReason != instagram_fans.AccountReasonHelpConfirm {
+				t.Errorf("confirm account got %v", account)
+			}
+		case "good":
+			if account.Status != instagram_fans.AccountStatusIdle {
+				t.Errorf("good account should be released, got %v", account)
+			}
+		}
+	}
+
+	if len(scrapers) != 1 || !scrapers[0].Closed() {
+		t.Fatalf("expect one scraper closed at the end, got %d", len(scrapers))
+	}
+	if fetched := scrapers[0].Fetched(); len(fetched) != 6 {
+		t.Fatalf("expect 6 fetches including the retry after relogin, got %v", fetched)
+	}
+}
+
+func TestUpdateDataGivesUpAfterRepeatedRelogin(t *testing.T) {
+	const (
+		loopUrl = "https://www.instagram.com/login_wall"
+		okUrl   = "https://www.instagram.com/ok"
+	)
+	var scraper *fakeScraper
+	factory := func(appContext *instagram_fans.AppContext, account *instagram_fans.Account) (instagram_fans.Scraper, error) {
+		scraper = &fakeScraper{
+			Account:      account,
+			Profiles:     map[string]instagram_fans.ProfileResult{okUrl: {FansCount: 100}},
+			AlwaysErrors: map[string]error{loopUrl: instagram_fans.ErrNeedLogin},
+		}
+		return scraper, nil
+	}
+	appContext := newFakeAppContext(t, factory)
+	appContext.Db.Table(appContext.Config.AccountTable).Create(&instagram_fans.Account{Username: "good", Password: "psw"})
+	appContext.Db.Table(appContext.Config.Table).Create(&instagram_fans.User{Url: loopUrl})
+	appContext.Db.Table(appContext.Config.Table).Create(&instagram_fans.User{Url: okUrl})
+
+	if err := updateData(appContext, 1); err != nil {
+		t.Fatalf("update data: %v", err)
+	}
+
+	var user instagram_fans.User
+	appContext.Db.Table(appContext.Config.Table).Where("url = ?", loopUrl).First(&user)
+	if user.ScrapeStatus != instagram_fans.ScrapeStatusFailed {
+		t.Errorf("blogger behind a login wall got %s, want failed", user.ScrapeStatus)
+	}
+	// 登录墙只算这个博主失败, 同一个账号继续抓下一个博主
+	var next instagram_fans.User
+	appContext.Db.Table(appContext.Config.Table).Where("url = ?", okUrl).First(&next)
+	if next.ScrapeStatus != instagram_fans.ScrapeStatusDone {
+		t.Errorf("next blogger got %s, want done", next.ScrapeStatus)
+	}
+	if fetched := scraper.Fetched(); len(fetched) != maxReloginsPerBlogger+2 {
+		t.Errorf("expect %d fetches, got %v", maxReloginsPerBlogger+2, fetched)
+	}
+	var account instagram_fans.Account
+	appContext.Db.Table(appContext.Config.AccountTable).Where("user = ?", "good").First(&account)
+	if account.Status != instagram_fans.AccountStatusIdle {
+		t.Errorf("account whose relogins succeeded should stay usable, got %v", account)
+	}
+}